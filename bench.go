@@ -0,0 +1,284 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/dkorunic/findlargedir/cerrgroup"
+)
+
+// benchResult is metadata operation rates per second measured at a given concurrency.
+type benchResult struct {
+	Concurrency int     `json:"concurrency"`
+	Creates     float64 `json:"creates"`
+	Stats       float64 `json:"stats"`
+	Readdir     float64 `json:"readdir"`
+	Unlinks     float64 `json:"unlinks"`
+}
+
+// benchReport is JSON report of a benchmark of a single root directory.
+type benchReport struct {
+	Root    string        `json:"root"`
+	Results []benchResult `json:"results"`
+}
+
+// benchReports is a list of benchmark reports saved to a file.
+type benchReports []benchReport
+
+// benchLevels returns concurrency levels to benchmark: sequential, one per CPU and four per CPU.
+func benchLevels() []int {
+	levels := []int{1}
+	for _, c := range []int{runtime.NumCPU(), 4 * runtime.NumCPU()} {
+		if c > levels[len(levels)-1] {
+			levels = append(levels, c)
+		}
+	}
+	return levels
+}
+
+// runBench will benchmark metadata operations in all roots and return the exit status.
+func runBench(roots []string) int {
+	var saved benchReports
+	if *benchFile != "" {
+		var err error
+		if saved, err = loadBenchReports(*benchFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Fatalf("Unable to load benchmark results: %v.", err)
+		}
+	}
+
+	status := 0
+	for _, root := range roots {
+		report, err := benchDirectory(filepath.Clean(root))
+		if err != nil {
+			log.Print(err)
+			status = 1
+			continue
+		}
+
+		if *jsonFlag {
+			if err := json.NewEncoder(os.Stdout).Encode(report); err != nil {
+				log.Fatalf("Unable to write JSON report: %v.", err)
+			}
+		}
+		saved = saved.merge(*report)
+	}
+
+	if *benchFile != "" {
+		if err := saved.save(*benchFile); err != nil {
+			log.Printf("Unable to save benchmark results: %v.", err)
+			status = 1
+		}
+	}
+
+	return status
+}
+
+// loadBenchReports will read benchmark reports from a file.
+func loadBenchReports(name string) (benchReports, error) {
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var b benchReports
+	if err := json.Unmarshal(content, &b); err != nil {
+		return nil, fmt.Errorf("invalid benchmark results %q: %w", name, err)
+	}
+
+	return b, nil
+}
+
+// save will atomically replace benchmark results file.
+func (b benchReports) save(name string) error {
+	content, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+
+	temp := name + ".tmp"
+	if err := os.WriteFile(temp, content, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(temp, name)
+}
+
+// merge returns reports with r added under its absolute root pathname, replacing an earlier report of the same root.
+func (b benchReports) merge(r benchReport) benchReports {
+	if abs, err := filepath.Abs(r.Root); err == nil {
+		r.Root = abs
+	}
+
+	for i := range b {
+		if b[i].Root == r.Root {
+			b[i] = r
+			return b
+		}
+	}
+	return append(b, r)
+}
+
+// readdirRate returns sequential directory listing rate benchmarked on the closest directory containing rootPath,
+// along with that directory. Rate is zero if there is no such benchmark.
+func (b benchReports) readdirRate(rootPath string) (string, float64) {
+	abs, err := filepath.Abs(rootPath)
+	if err != nil {
+		return "", 0
+	}
+
+	var root string
+	var rate float64
+	for i := range b {
+		rel, err := filepath.Rel(b[i].Root, abs)
+		if err != nil || isOutside(rel) || len(b[i].Root) <= len(root) {
+			continue
+		}
+		for _, r := range b[i].Results {
+			if r.Concurrency == 1 && r.Readdir > 0 {
+				root, rate = b[i].Root, r.Readdir
+			}
+		}
+	}
+
+	return root, rate
+}
+
+// benchDirectory will benchmark metadata operations in a calibration directory in rootPath at each concurrency level.
+func benchDirectory(rootPath string) (*benchReport, error) {
+	log.Printf("Benchmarking metadata operations on %q. Please wait, creating %v files for each concurrency level...",
+		rootPath, *testFileCount)
+
+	tempDir, err := makeCalibrationDir(rootPath)
+	if err != nil {
+		return nil, err
+	}
+	defer removeOnSignal(tempDir)()
+	defer removeAllThrottled(tempDir)
+
+	report := &benchReport{Root: rootPath}
+	for _, c := range benchLevels() {
+		r, err := benchLevel(tempDir, c)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Printf("Concurrency %v on %q: %.0f creates/s, %.0f stats/s, %.0f readdir entries/s, %.0f unlinks/s.",
+			c, rootPath, r.Creates, r.Stats, r.Readdir, r.Unlinks)
+		report.Results = append(report.Results, r)
+	}
+
+	return report, nil
+}
+
+// benchLevel will create, list, stat and unlink test files in a directory of its own at given concurrency. Directory
+// listing is always sequential.
+func benchLevel(tempDir string, concurrency int) (benchResult, error) {
+	r := benchResult{Concurrency: concurrency}
+
+	dir := filepath.Join(tempDir, strconv.Itoa(concurrency))
+	if err := os.Mkdir(dir, 0o700); err != nil {
+		return r, err
+	}
+
+	start := time.Now()
+	if err := createFiles(dir, *testFileCount, concurrency, calibrationShortName); err != nil {
+		return r, err
+	}
+	r.Creates = perSecond(*testFileCount, time.Since(start))
+
+	start = time.Now()
+	names, err := readNames(dir)
+	if err != nil {
+		return r, err
+	}
+	r.Readdir = perSecond(int64(len(names)), time.Since(start))
+
+	start = time.Now()
+	if err := forEachName(dir, names, concurrency, func(path string) error {
+		_, err := os.Lstat(path)
+		return err
+	}); err != nil {
+		return r, err
+	}
+	r.Stats = perSecond(int64(len(names)), time.Since(start))
+
+	start = time.Now()
+	if err := forEachName(dir, names, concurrency, os.Remove); err != nil {
+		return r, err
+	}
+	r.Unlinks = perSecond(int64(len(names)), time.Since(start))
+
+	return r, os.Remove(dir)
+}
+
+// readdirRate will measure directory listing rate in entries per second.
+func readdirRate(dir string) (float64, error) {
+	start := time.Now()
+	names, err := readNames(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	return perSecond(int64(len(names)), time.Since(start)), nil
+}
+
+// readNames will read all entry names of a directory, unsorted.
+func readNames(dir string) ([]string, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return f.Readdirnames(-1)
+}
+
+// forEachName will call fn for pathname of every name in dir, running at most concurrency calls at once, paced by
+// metadata throttle.
+func forEachName(dir string, names []string, concurrency int, fn func(path string) error) error {
+	cg := cerrgroup.New(concurrency)
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		cg.Go(func() error {
+			metadataThrottle.wait(1)
+			return fn(path)
+		})
+	}
+
+	return cg.Wait()
+}
+
+// perSecond returns rate of count operations done in elapsed time.
+func perSecond(count int64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(count) / elapsed.Seconds()
+}
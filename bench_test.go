@@ -0,0 +1,83 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestBenchLevel(t *testing.T) {
+	setTestFileCount(t, 100)
+	tempDir := t.TempDir()
+
+	r, err := benchLevel(tempDir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Concurrency != 2 || r.Creates <= 0 || r.Stats <= 0 || r.Readdir <= 0 || r.Unlinks <= 0 {
+		t.Errorf("benchLevel() = %+v; want all rates positive", r)
+	}
+
+	if names, err := readNames(tempDir); err != nil || len(names) != 0 {
+		t.Errorf("benchLevel() left %v behind (%v)", names, err)
+	}
+}
+
+func TestBenchReportsReaddirRate(t *testing.T) {
+	results := func(rate float64) []benchResult {
+		return []benchResult{{Concurrency: 1, Readdir: rate}, {Concurrency: 4, Readdir: 4 * rate}}
+	}
+
+	var b benchReports
+	b = b.merge(benchReport{Root: "/srv", Results: results(100)})
+	b = b.merge(benchReport{Root: "/srv/data", Results: results(200)})
+	b = b.merge(benchReport{Root: "/srv/data", Results: results(300)})
+	b = b.merge(benchReport{Root: "/srv/empty"})
+	if len(b) != 3 {
+		t.Errorf("merge() kept %v reports; want 3", len(b))
+	}
+
+	cases := []struct {
+		root     string
+		wantRoot string
+		wantRate float64
+	}{
+		{"/srv", "/srv", 100},
+		{"/srv/other", "/srv", 100},
+		{"/srv/data/deep", "/srv/data", 300},
+		{"/srv/datastore", "/srv", 100},
+		{"/srv/empty/dir", "/srv", 100},
+		{"/home", "", 0},
+	}
+
+	for _, tc := range cases {
+		wantRoot := tc.wantRoot
+		if wantRoot != "" {
+			wantRoot, _ = filepath.Abs(filepath.FromSlash(wantRoot))
+		}
+		root, rate := b.readdirRate(filepath.FromSlash(tc.root))
+		if root != wantRoot || rate != tc.wantRate {
+			t.Errorf("readdirRate(%q) = %q, %v; want %q, %v", tc.root, root, rate, wantRoot, tc.wantRate)
+		}
+	}
+}
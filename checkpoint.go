@@ -0,0 +1,178 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const defaultCheckpointInterval = time.Minute
+
+// savedFit is sizeFit in a checkpoint.
+type savedFit struct {
+	Intercept   float64 `json:"intercept"`
+	Slope       float64 `json:"slope"`
+	Granularity float64 `json:"granularity"`
+	Residual    float64 `json:"residual"`
+}
+
+// savedRatio is inodeRatio in a checkpoint.
+type savedRatio struct {
+	Short       savedFit `json:"short"`
+	Long        savedFit `json:"long"`
+	ReaddirRate float64  `json:"readdir_rate"`
+}
+
+// rootCheckpoint is walk state of a single root directory.
+type rootCheckpoint struct {
+	Root        string     `json:"root"`
+	Dev         uint64     `json:"dev"`
+	Ino         uint64     `json:"ino"`
+	Ratio       savedRatio `json:"ratio"`
+	NlinkUsable bool       `json:"nlink_usable"`
+	NameCount   int64      `json:"name_count"`
+	NameTotal   int64      `json:"name_total"`
+	DirsChecked int64      `json:"directories_checked"`
+	// Completed holds directories whose whole subtree has been walked, but not their subdirectories
+	Completed []string         `json:"completed"`
+	Offenders []offenderReport `json:"offenders"`
+	Complete  bool             `json:"complete"`
+	Updated   time.Time        `json:"updated"`
+}
+
+// checkpoint is walk state of all root directories, saved to resume interrupted scans.
+type checkpoint struct {
+	Roots map[string]*rootCheckpoint `json:"roots"`
+}
+
+// newCheckpoint returns empty checkpoint.
+func newCheckpoint() *checkpoint {
+	return &checkpoint{Roots: map[string]*rootCheckpoint{}}
+}
+
+// loadCheckpoint will read checkpoint from a file.
+func loadCheckpoint(name string) (*checkpoint, error) {
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	c := newCheckpoint()
+	if err := json.Unmarshal(content, c); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint %q: %w", name, err)
+	}
+	if c.Roots == nil {
+		c.Roots = map[string]*rootCheckpoint{}
+	}
+
+	return c, nil
+}
+
+// save will atomically replace checkpoint file.
+func (c *checkpoint) save(name string) error {
+	content, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	temp := name + ".tmp"
+	if err := os.WriteFile(temp, content, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(temp, name)
+}
+
+// resumable returns saved state of rootPath if it can be resumed, that is if root is still the same directory.
+func (c *checkpoint) resumable(rootPath string, rootStat *dirInfo) (*rootCheckpoint, error) {
+	rc, ok := c.Roots[rootPath]
+	if !ok {
+		return nil, nil
+	}
+	if rc.Dev != rootStat.dev || rc.Ino != rootStat.ino {
+		return nil, fmt.Errorf("directory %q is no longer on the same device or inode", rootPath)
+	}
+
+	return rc, nil
+}
+
+// saveRatio returns inodeRatio for a checkpoint.
+func saveRatio(r inodeRatio) savedRatio {
+	save := func(f sizeFit) savedFit {
+		return savedFit{Intercept: f.intercept, Slope: f.slope, Granularity: f.granularity, Residual: f.residual}
+	}
+	return savedRatio{Short: save(r.short), Long: save(r.long), ReaddirRate: r.readdirRate}
+}
+
+// restore returns inodeRatio from a checkpoint.
+func (s savedRatio) restore() inodeRatio {
+	restore := func(f savedFit) sizeFit {
+		return sizeFit{intercept: f.Intercept, slope: f.Slope, granularity: f.Granularity, residual: f.Residual}
+	}
+	return inodeRatio{short: restore(s.Short), long: restore(s.Long), readdirRate: s.ReaddirRate}
+}
+
+// completedDirs keeps directories whose whole subtree has been walked. Once a directory is completed, its completed
+// subdirectories are dropped, so only completed children of directories still being walked are kept.
+type completedDirs map[string]map[string]struct{}
+
+// newCompletedDirs returns completed directories from a checkpoint.
+func newCompletedDirs(completed []string) completedDirs {
+	c := completedDirs{}
+	for _, path := range completed {
+		c.add(path)
+	}
+	return c
+}
+
+// add will mark a directory as completed.
+func (c completedDirs) add(osPathname string) {
+	delete(c, osPathname)
+
+	parent := filepath.Dir(osPathname)
+	if c[parent] == nil {
+		c[parent] = map[string]struct{}{}
+	}
+	c[parent][osPathname] = struct{}{}
+}
+
+// has checks if a directory has been completed.
+func (c completedDirs) has(osPathname string) bool {
+	_, ok := c[filepath.Dir(osPathname)][osPathname]
+	return ok
+}
+
+// list returns all completed directories.
+func (c completedDirs) list() []string {
+	var l []string
+	for _, children := range c {
+		for path := range children {
+			l = append(l, path)
+		}
+	}
+	sort.Strings(l)
+	return l
+}
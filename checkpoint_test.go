@@ -0,0 +1,89 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCompletedDirs(t *testing.T) {
+	completed := completedDirs{}
+	completed.add("/a/b/c")
+	completed.add("/a/b/d")
+
+	if !completed.has("/a/b/c") || !completed.has("/a/b/d") || completed.has("/a/b") {
+		t.Errorf("unexpected completed directories: %v", completed.list())
+	}
+
+	// Completing a parent replaces its children
+	completed.add("/a/b")
+	if got, want := completed.list(), []string{"/a/b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	restored := newCompletedDirs(completed.list())
+	if !restored.has("/a/b") || restored.has("/a") {
+		t.Errorf("unexpected restored directories: %v", restored.list())
+	}
+}
+
+func TestCheckpointSaveLoad(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	ratio := inodeRatio{
+		short:       sizeFit{intercept: 4096, slope: 28, granularity: 4096},
+		long:        sizeFit{intercept: 4096, slope: 156, granularity: 4096},
+		readdirRate: 1e6,
+	}
+	state := newCheckpoint()
+	state.Roots["/a"] = &rootCheckpoint{
+		Root:        "/a",
+		Dev:         1,
+		Ino:         2,
+		Ratio:       saveRatio(ratio),
+		DirsChecked: 10,
+		Completed:   []string{"/a/b"},
+	}
+	if err := state.save(name); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := loadCheckpoint(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := loaded.resumable("/a", &dirInfo{dev: 1, ino: 2})
+	if err != nil || root == nil {
+		t.Fatalf("root not resumable: %v", err)
+	}
+	if got := root.Ratio.restore(); got != ratio {
+		t.Errorf("got ratio %+v, want %+v", got, ratio)
+	}
+
+	// A different directory at the same path cannot be resumed
+	if _, err := loaded.resumable("/a", &dirInfo{dev: 1, ino: 3}); err == nil {
+		t.Error("expected error resuming replaced root")
+	}
+}
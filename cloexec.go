@@ -19,7 +19,8 @@
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
 // SOFTWARE.
 
-// +build freebsd,amd64
+//go:build freebsd
+// +build freebsd
 
 package main
 
@@ -32,10 +33,15 @@ import (
 )
 
 // patchSyscallOpen will attempt to monkey patch syscall.Open and avoid using O_CLOEXEC.
-func patchSyscallOpen() {
+func patchSyscallOpen() error {
+	if err := monkey.CanPatch(syscall.Open); err != nil {
+		return err
+	}
+
 	log.Print("Attempting to monkey patch syscall.Open. We might horribly crash here...")
 	monkey.Patch(syscall.Open, syscallOpenNoCloexec)
 	log.Print("Patching syscall.Open done.")
+	return nil
 }
 
 // syscallOpenNoCloexec is a FreeBSD and Isilon kernel syscall.Open() wrapper masking away O_CLOEXEC.
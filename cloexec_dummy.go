@@ -19,11 +19,17 @@
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
 // SOFTWARE.
 
-// +build !freebsd !amd64
+//go:build !freebsd
+// +build !freebsd
 
 package main
 
-// patchSyscallOpen is just a dummy function.
-func patchSyscallOpen() {
-	// do nothing
+import (
+	"fmt"
+	"runtime"
+)
+
+// patchSyscallOpen always fails as cloexec mode is FreeBSD specific.
+func patchSyscallOpen() error {
+	return fmt.Errorf("cloexec mode is not supported on %v/%v", runtime.GOOS, runtime.GOARCH)
 }
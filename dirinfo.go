@@ -0,0 +1,46 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/dkorunic/findlargedir/isilonstat"
+)
+
+// fileInfoToDirInfo converts os.Stat results, either native or Isilon ones, to dirInfo.
+func fileInfoToDirInfo(fi os.FileInfo) *dirInfo {
+	di := &dirInfo{size: fi.Size(), dir: fi.IsDir()}
+
+	switch st := fi.Sys().(type) {
+	case *syscall.Stat_t:
+		di.dev, di.ino, di.nlink = uint64(st.Dev), uint64(st.Ino), uint64(st.Nlink)
+	case *isilonstat.IsilonStat_t:
+		di.dev, di.ino, di.nlink = uint64(st.Dev), st.Ino, uint64(st.Nlink)
+	}
+
+	return di
+}
@@ -0,0 +1,78 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"math"
+)
+
+// sizePoint is directory inode size measured with a given number of files.
+type sizePoint struct {
+	files int64
+	size  int64
+}
+
+// sizeFit is a linear model of directory inode size as a function of file count. Directory inodes grow in steps of
+// granularity bytes (a filesystem block for instance) and residual is the largest deviation of a measured size from
+// the model.
+type sizeFit struct {
+	intercept   float64
+	slope       float64
+	granularity float64
+	residual    float64
+}
+
+// fitSizes will fit a line through measured sizes using least squares, and estimate inode size granularity as the
+// greatest common divisor of all measured sizes.
+func fitSizes(points []sizePoint) sizeFit {
+	var sumX, sumY, sumXX, sumXY float64
+	var granularity int64
+	for _, p := range points {
+		x, y := float64(p.files), float64(p.size)
+		sumX += x
+		sumY += y
+		sumXX += x * x
+		sumXY += x * y
+		granularity = gcd(granularity, p.size)
+	}
+
+	n := float64(len(points))
+	f := sizeFit{granularity: float64(granularity)}
+	if d := n*sumXX - sumX*sumX; d != 0 {
+		f.slope = (n*sumXY - sumX*sumY) / d
+	}
+	f.intercept = (sumY - f.slope*sumX) / n
+
+	for _, p := range points {
+		f.residual = math.Max(f.residual, math.Abs(float64(p.size)-f.intercept-f.slope*float64(p.files)))
+	}
+
+	return f
+}
+
+// gcd returns greatest common divisor of a and b.
+func gcd(a, b int64) int64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
@@ -0,0 +1,51 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestFitSizes(t *testing.T) {
+	// 4096 bytes blocks, 32 bytes per file
+	points := []sizePoint{{0, 4096}, {256, 12288}, {512, 20480}, {768, 28672}, {1024, 36864}}
+	f := fitSizes(points)
+
+	if f.slope != 32 || f.intercept != 4096 || f.granularity != 4096 || f.residual != 0 {
+		t.Errorf("fitSizes() = %+v; want slope 32, intercept 4096, granularity 4096, residual 0", f)
+	}
+
+	r := inodeRatio{short: f, long: f}
+	e := r.estimate(4096+32*1024, calibrationShortName)
+	if e.best != 1024 || e.lower != 960 || e.upper != 1088 {
+		t.Errorf("estimate() = %+v; want 1024 between 960 and 1088", e)
+	}
+	for mode, want := range map[string]int64{thresholdBest: 1024, thresholdDefinite: 960, thresholdPossible: 1088} {
+		if got := e.value(mode); got != want {
+			t.Errorf("value(%v) = %v; want %v", mode, got, want)
+		}
+	}
+
+	if e := r.estimate(4096, calibrationShortName); e.best != 0 || e.lower != 0 || e.upper != 64 {
+		t.Errorf("estimate() of empty directory = %+v; want 0 between 0 and 64", e)
+	}
+}
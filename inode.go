@@ -25,36 +25,256 @@ import (
 	"github.com/dkorunic/findlargedir/cerrgroup"
 	"io/ioutil"
 	"log"
+	"math"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 const testContent = "Death is lighter than a feather, but Duty is heavier than a mountain."
 const minRatio = 1
 const maxRatio = 128
 
-// getInodeRatio will do a rough estimation on how much a single file occupies in a directory inode.
-func getInodeRatio(checkDir string) (ratio float64) {
+// Calibration file names are a prefix followed by about 10 random digits
+const calibrationRandomLength = 10
+const calibrationShortName = calibrationRandomLength
+const calibrationLongName = 100
+
+// Directory inode size is measured after every step of creating calibration files
+const calibrationSteps = 4
+
+// cleanupAttempts is how many times removal of calibration directory is attempted on signal.
+const cleanupAttempts = 10
+
+// Threshold modes compare alert threshold to lower bound, upper bound or best estimate of entry count
+const (
+	thresholdDefinite = "definite"
+	thresholdPossible = "possible"
+	thresholdBest     = "best"
+)
+
+// inodeRatio is directory inode size to file count ratio as a function of file name length, interpolated between
+// size models fitted with short and long file names.
+type inodeRatio struct {
+	short, long sizeFit
+	// readdirRate is directory listing rate in entries per second, measured on calibration files
+	readdirRate float64
+}
+
+// entryEstimate is estimated directory entry count with its lower and upper bound.
+type entryEstimate struct {
+	best, lower, upper int64
+}
+
+// valid checks if ratio was successfully calibrated.
+func (r inodeRatio) valid() bool {
+	return r.short.slope > 0 && r.long.slope > 0
+}
+
+// at returns ratio for files with given name length.
+func (r inodeRatio) at(nameLength float64) float64 {
+	ratio := r.short.slope + (r.long.slope-r.short.slope)*(nameLength-calibrationShortName)/
+		(calibrationLongName-calibrationShortName)
+
+	return math.Min(math.Max(ratio, minRatio), maxRatioFor(nameLength))
+}
+
+// estimate returns entry count for a directory of given inode size holding files with given name length. Bounds
+// account for inode size granularity, as inode of a given size holds anything from one granule less, and for the
+// largest deviation of calibration measurements from the model.
+func (r inodeRatio) estimate(size int64, nameLength float64) entryEstimate {
+	ratio := r.at(nameLength)
+	intercept := r.intercept()
+	margin := math.Max(r.short.granularity, r.long.granularity)/2 + math.Max(r.short.residual, r.long.residual)
+
+	count := func(size float64) int64 {
+		return int64(math.Max((size-intercept)/ratio, 0))
+	}
+
+	s := float64(size)
+	return entryEstimate{best: count(s), lower: count(s - margin), upper: count(s + margin)}
+}
+
+// listingTime returns estimated time to list a directory with given number of entries, as ls or accurate mode do. As
+// listing rate is measured on calibration files just created, it is a lower bound: with a cold cache, as large
+// directories usually are, listing can take much longer.
+func (r inodeRatio) listingTime(entries int64) time.Duration {
+	if r.readdirRate <= 0 {
+		return 0
+	}
+	return time.Duration(float64(entries) / r.readdirRate * float64(time.Second))
+}
+
+// intercept returns empty directory inode size according to the model.
+func (r inodeRatio) intercept() float64 {
+	return (r.short.intercept + r.long.intercept) / 2
+}
+
+// scaled returns ratio multiplied by factor, for instance after refining it with exact counts.
+func (r inodeRatio) scaled(factor float64) inodeRatio {
+	r.short.slope *= factor
+	r.long.slope *= factor
+	return r
+}
+
+// atLeast raises estimate to a known minimum entry count.
+func (e entryEstimate) atLeast(count int64) entryEstimate {
+	if e.best < count {
+		e.best = count
+	}
+	if e.lower < count {
+		e.lower = count
+	}
+	if e.upper < count {
+		e.upper = count
+	}
+	return e
+}
+
+// value returns entry count to compare with alert threshold in given threshold mode.
+func (e entryEstimate) value(mode string) int64 {
+	switch mode {
+	case thresholdDefinite:
+		return e.lower
+	case thresholdPossible:
+		return e.upper
+	}
+	return e.best
+}
+
+// maxRatioFor returns upper sanity bound of ratio for files with given name length, as each directory entry holds
+// its name besides some fixed overhead.
+func maxRatioFor(nameLength float64) float64 {
+	return maxRatio + nameLength
+}
+
+// nameSampler keeps mean name length of directory entries seen while walking.
+type nameSampler struct {
+	count, total int64
+}
+
+// minNameSamples is the number of names needed before sampled mean name length is used.
+const minNameSamples = 100
+
+// add will record a name length.
+func (s *nameSampler) add(nameLength int) {
+	s.count++
+	s.total += int64(nameLength)
+}
+
+// mean returns mean name length, or calibration short name length until enough names were sampled.
+func (s *nameSampler) mean() float64 {
+	if s.count < minNameSamples {
+		return calibrationShortName
+	}
+	return float64(s.total) / float64(s.count)
+}
+
+// nameSampleSize is the number of names read from a directory to sample its own name lengths.
+const nameSampleSize = 256
+
+// dirNameLength returns name length to estimate entries of a directory of given size with. Names of directories which
+// could reach nearThreshold entries even with the shortest names are sampled, so that estimates of a directory don't
+// depend on names elsewhere in the tree. For the rest, and when sampling fails, mean of names seen so far is used.
+func dirNameLength(osPathname string, size int64, r inodeRatio, nearThreshold int64, seen float64) float64 {
+	if r.estimate(size, 1).upper < nearThreshold {
+		return seen
+	}
+	if mean, ok := sampleNameLength(osPathname); ok {
+		return mean
+	}
+	return seen
+}
+
+// sampleNameLength returns mean name length of up to nameSampleSize entries of a directory, or false if it has none
+// or they can't be read.
+func sampleNameLength(osPathname string) (float64, bool) {
+	metadataThrottle.wait(1)
+	var names []string
+	err := guardCall("readdir", osPathname, func() error {
+		f, err := os.Open(osPathname)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		names, err = f.Readdirnames(nameSampleSize)
+		return err
+	}, nil)
+	if err != nil || len(names) == 0 {
+		return 0, false
+	}
+
+	var total int
+	for _, name := range names {
+		total += len(name)
+	}
+	return float64(total) / float64(len(names)), true
+}
+
+// getInodeRatio will do a rough estimation on how much a single file occupies in a directory inode, for short and
+// long file names. Each half of test files is created in its own directory, one for each name length.
+func getInodeRatio(checkDir string) (ratio inodeRatio) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("Errors encountered, skipping directory scan on %q.", checkDir)
-			ratio = 0
+			ratio = inodeRatio{}
 		}
 	}()
 
 	log.Printf("Determining inode to file count ratio on %q. Please wait, creating %v files...", checkDir,
 		*testFileCount)
 
-	var wg sync.WaitGroup
-
 	// Create a temporary directory in each root filesystem path and remove on exit
-	tempDir, err := ioutil.TempDir(checkDir, testDirName)
+	tempDir, err := makeCalibrationDir(checkDir)
 	if err != nil {
 		log.Print(err)
 		return
 	}
-	defer os.RemoveAll(tempDir)
+	defer removeOnSignal(tempDir)()
+	defer removeAllThrottled(tempDir)
+
+	fileCount := *testFileCount / 2
+	short, ok := measureSizes(tempDir, fileCount, calibrationShortName)
+	if !ok {
+		return
+	}
+	long, ok := measureSizes(tempDir, fileCount, calibrationLongName)
+	if !ok {
+		return
+	}
+	ratio = inodeRatio{short: short, long: long}
+
+	// Measure directory listing rate to estimate listing times of large directories
+	longDir := calibrationSubdir(tempDir, calibrationLongName)
+	err = guardCall("readdir", longDir, func() (err error) {
+		ratio.readdirRate, err = readdirRate(longDir)
+		return err
+	}, nil)
+	if err != nil {
+		ratio.readdirRate = 0
+		log.Print(err)
+	}
+
+	log.Printf("Done. Approximate directory inode size to file count ratio on %q is %.2f for %v and %.2f for %v character long names, size granularity is %v bytes.",
+		checkDir, short.slope, calibrationShortName, long.slope, calibrationLongName,
+		math.Max(short.granularity, long.granularity))
+	return
+}
+
+// calibrationSubdir returns directory for calibration files with names of about nameLength characters.
+func calibrationSubdir(tempDir string, nameLength int) string {
+	return filepath.Join(tempDir, strconv.Itoa(nameLength))
+}
+
+// removeOnSignal will remove tempDir and exit on SIGINT and SIGTERM, until the returned function is called.
+func removeOnSignal(tempDir string) func() {
+	var wg sync.WaitGroup
 
 	// Signal handler variables
 	signalChan := make(chan os.Signal, 1)
@@ -70,7 +290,9 @@ func getInodeRatio(checkDir string) (ratio float64) {
 			select {
 			case <-signalChan:
 				log.Printf("Cleaning up temporary directory %v, please wait...", tempDir)
-				os.RemoveAll(tempDir)
+				// Files might still be being created, so retry until the directory is gone
+				for i := 0; i < cleanupAttempts && os.RemoveAll(tempDir) != nil; i++ {
+				}
 				log.Printf("Exiting program as requested.")
 				os.Exit(1)
 			case <-doneSignalChan:
@@ -79,81 +301,136 @@ func getInodeRatio(checkDir string) (ratio float64) {
 		}
 	}()
 
-	// Get empty directory inode size
-	dirSizeEmpty, err := getDirSize(tempDir)
-	if err != nil {
-		log.Print(err)
-		return
+	// Close channels and cleanup routines
+	return func() {
+		signal.Stop(signalChan)
+		doneSignalChan <- struct{}{}
+		wg.Wait()
 	}
+}
 
-	// Highly concurrent file creation routine with at most NumCPU() running routines
-	cg := cerrgroup.New(runtime.NumCPU())
+// createFiles will create count files with names of about nameLength characters in dir, running at most concurrency
+// file creations at once.
+func createFiles(dir string, count int64, concurrency int, nameLength int) error {
+	cg := cerrgroup.New(concurrency)
 	content := []byte(testContent)
-	for i := int64(0); i < *testFileCount; i++ {
+	prefix := strings.Repeat("f", nameLength-calibrationRandomLength)
+	for i := int64(0); i < count; i++ {
 		cg.Go(func() error {
-			t, err := ioutil.TempFile(tempDir, "")
+			metadataThrottle.wait(1)
+			err := guardCall("create", dir, func() error {
+				return createFile(dir, prefix, content)
+			}, nil)
+			if err == errStall {
+				err = &os.PathError{Op: "create", Path: dir, Err: err}
+			}
 			if err != nil {
 				log.Print(err)
-				return err
 			}
+			return err
+		})
+	}
 
-			if _, err := t.Write(content); err != nil {
-				log.Print(err)
-				return err
-			}
+	// Wait for all routines to finish
+	return cg.Wait()
+}
 
-			if err := t.Close(); err != nil {
-				log.Print(err)
-				return err
-			}
+// createFile will create a file with given name prefix and content in dir.
+func createFile(dir, prefix string, content []byte) error {
+	t, err := ioutil.TempFile(dir, prefix)
+	if err != nil {
+		return err
+	}
 
-			return nil
-		})
+	if _, err := t.Write(content); err != nil {
+		t.Close()
+		return err
 	}
 
-	// Wait for all routines to finish
-	if err = cg.Wait(); err != nil {
+	return t.Close()
+}
+
+// measureSizes will create a directory in tempDir with fileCount files having names of about nameLength characters,
+// measure its inode size after each of calibrationSteps steps and fit a size model to the measurements.
+func measureSizes(tempDir string, fileCount int64, nameLength int) (sizeFit, bool) {
+	dir := calibrationSubdir(tempDir, nameLength)
+	if err := mkdirGuarded(dir); err != nil {
 		log.Print(err)
-		return
+		return sizeFit{}, false
 	}
 
-	// Get full directory inode size
-	dirSizeFull, err := getDirSize(tempDir)
+	// Get empty directory inode size
+	dirSizeEmpty, err := getDirSize(dir)
 	if err != nil {
 		log.Print(err)
-		return
+		return sizeFit{}, false
+	}
+	points := []sizePoint{{files: 0, size: dirSizeEmpty}}
+
+	var created int64
+	for step := int64(1); step <= calibrationSteps; step++ {
+		// Highly concurrent file creation routine with at most NumCPU() running routines
+		stepCount := fileCount*step/calibrationSteps - created
+		if err := createFiles(dir, stepCount, runtime.NumCPU(), nameLength); err != nil {
+			log.Print(err)
+			return sizeFit{}, false
+		}
+		created += stepCount
+
+		// Get directory inode size at this fill level
+		dirSize, err := getDirSize(dir)
+		if err != nil {
+			log.Print(err)
+			return sizeFit{}, false
+		}
+		points = append(points, sizePoint{files: created, size: dirSize})
 	}
 
 	// Stat st_size value sanity check
-	if dirSizeFull < (minRatio**testFileCount) || dirSizeFull > (maxRatio**testFileCount) {
+	dirSizeFull := points[len(points)-1].size
+	maxSize := int64(maxRatioFor(float64(nameLength))) * fileCount
+	if dirSizeFull < (minRatio*fileCount) || dirSizeFull > maxSize {
 		log.Printf("Directory stat st_size structure is most likely incorrect (%v bytes used). Skipping folder checks.",
 			dirSizeFull)
-		return
+		return sizeFit{}, false
 	}
 
-	// Calculate final file inode usage ratio
-	ratio = float64(dirSizeFull-dirSizeEmpty) / float64(*testFileCount)
+	// Fit final file inode usage model
+	fit := fitSizes(points)
 
 	// Ratio sanity check
-	if ratio < minRatio || ratio > maxRatio {
-		log.Printf("Calculated ratio (%v) failed sanity checking. Skipping folder checks.", ratio)
-		ratio = 0
-		return
+	if fit.slope < minRatio || fit.slope > maxRatioFor(float64(nameLength)) {
+		log.Printf("Calculated ratio (%v) failed sanity checking. Skipping folder checks.", fit.slope)
+		return sizeFit{}, false
 	}
 
-	// Close channels and cleanup routines
-	doneSignalChan <- struct{}{}
-	wg.Wait()
-
-	log.Printf("Done. Approximate directory inode size to file count ratio on %q is %v.", checkDir, ratio)
-	return
+	return fit, true
 }
 
 // getDirSize returns inode size from Fileinfo structure.
 func getDirSize(name string) (int64, error) {
-	fi, err := os.Stat(name)
+	metadataThrottle.wait(1)
+	var fi os.FileInfo
+	err := guardCall("stat", name, func() (err error) {
+		fi, err = os.Stat(name)
+		return err
+	}, nil)
+	if err == errStall {
+		return 0, &os.PathError{Op: "stat", Path: name, Err: err}
+	}
 	if err != nil {
 		return 0, err
 	}
 	return fi.Size(), err
 }
+
+// mkdirGuarded creates a calibration directory, giving up on stalled calls.
+func mkdirGuarded(name string) error {
+	err := guardCall("mkdir", name, func() error {
+		return os.Mkdir(name, 0o700)
+	}, nil)
+	if err == errStall {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	return err
+}
@@ -0,0 +1,180 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/dkorunic/findlargedir/monkey"
+)
+
+// fakeFileInfo is an os.FileInfo with a fixed st_size.
+type fakeFileInfo struct {
+	size int64
+}
+
+func (fi fakeFileInfo) Name() string       { return testDirName }
+func (fi fakeFileInfo) Size() int64        { return fi.size }
+func (fi fakeFileInfo) Mode() os.FileMode  { return os.ModeDir | 0o700 }
+func (fi fakeFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi fakeFileInfo) IsDir() bool        { return true }
+func (fi fakeFileInfo) Sys() interface{}   { return nil }
+
+// setTestFileCount overrides testcount parameter for the duration of a test.
+func setTestFileCount(t *testing.T, count int64) {
+	t.Helper()
+
+	saved := *testFileCount
+	*testFileCount = count
+	t.Cleanup(func() { *testFileCount = saved })
+}
+
+func TestGetInodeRatioFailures(t *testing.T) {
+	// ioutil.TempDir and ioutil.TempFile are inlined wrappers, patch what they call instead
+	cases := []struct {
+		name   string
+		target interface{}
+		fake   interface{}
+	}{
+		{
+			name:   "tempdir EACCES",
+			target: os.MkdirTemp,
+			fake: func(dir, pattern string) (string, error) {
+				return "", &os.PathError{Op: "mkdirtemp", Path: dir, Err: syscall.EACCES}
+			},
+		},
+		{
+			name:   "tempfile ENOSPC",
+			target: os.CreateTemp,
+			fake: func(dir, pattern string) (*os.File, error) {
+				return nil, &os.PathError{Op: "open", Path: dir, Err: syscall.ENOSPC}
+			},
+		},
+		{
+			name:   "tempfile EACCES",
+			target: os.CreateTemp,
+			fake: func(dir, pattern string) (*os.File, error) {
+				return nil, &os.PathError{Op: "open", Path: dir, Err: syscall.EACCES}
+			},
+		},
+		{
+			name:   "stat failure",
+			target: os.Stat,
+			fake: func(name string) (os.FileInfo, error) {
+				return nil, &os.PathError{Op: "stat", Path: name, Err: syscall.EIO}
+			},
+		},
+		{
+			name:   "st_size not growing",
+			target: os.Stat,
+			fake:   func(name string) (os.FileInfo, error) { return fakeFileInfo{size: 4096}, nil },
+		},
+		{
+			name:   "st_size too large",
+			target: os.Stat,
+			fake:   func(name string) (os.FileInfo, error) { return fakeFileInfo{size: 1 << 40}, nil },
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			if err := monkey.CanPatch(tc.target); err != nil {
+				t.Skip(err)
+			}
+
+			setTestFileCount(t, 100)
+			dir := t.TempDir()
+			monkey.PatchT(t, tc.target, tc.fake)
+
+			if ratio := getInodeRatio(dir); ratio.valid() {
+				t.Errorf("getInodeRatio() = %+v; want invalid ratio", ratio)
+			}
+		})
+	}
+}
+
+func TestInodeRatioAt(t *testing.T) {
+	r := inodeRatio{short: sizeFit{slope: 20}, long: sizeFit{slope: 110}}
+	cases := []struct {
+		nameLength float64
+		want       float64
+	}{
+		{calibrationShortName, 20},
+		{calibrationLongName, 110},
+		{40, 50},
+		{1, 11},
+		{-100, minRatio},
+	}
+
+	for _, tc := range cases {
+		if got := r.at(tc.nameLength); got != tc.want {
+			t.Errorf("at(%v) = %v; want %v", tc.nameLength, got, tc.want)
+		}
+	}
+
+	steep := inodeRatio{short: sizeFit{slope: 20}, long: sizeFit{slope: 1000}}
+	if got := steep.at(255); got != maxRatioFor(255) {
+		t.Errorf("at(255) = %v; want %v", got, maxRatioFor(255))
+	}
+}
+
+func TestDirNameLength(t *testing.T) {
+	r := inodeRatio{short: sizeFit{slope: 20}, long: sizeFit{slope: 110}}
+	dir := t.TempDir()
+	long := filepath.Join(dir, "long")
+	empty := filepath.Join(dir, "empty")
+	for _, d := range []string{long, empty} {
+		if err := os.Mkdir(d, 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{strings.Repeat("a", 30), strings.Repeat("b", 50)} {
+		if err := os.WriteFile(filepath.Join(long, name), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		name          string
+		pathname      string
+		size          int64
+		nearThreshold int64
+		want          float64
+	}{
+		{"small directory", long, 4096, 1000, 12},
+		{"sampled directory", long, 4096, 100, 40},
+		{"empty directory", empty, 4096, 100, 12},
+		{"missing directory", filepath.Join(dir, "missing"), 4096, 100, 12},
+	}
+
+	for _, tc := range cases {
+		if got := dirNameLength(tc.pathname, tc.size, r, tc.nearThreshold, 12); got != tc.want {
+			t.Errorf("%v: dirNameLength() = %v; want %v", tc.name, got, tc.want)
+		}
+	}
+}
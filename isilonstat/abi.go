@@ -0,0 +1,40 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package isilonstat
+
+// ABI is the stat and dirent structure flavour spoken by the running kernel.
+type ABI int
+
+const (
+	// ABINative is the stock FreeBSD ABI mapped in Golang syscall.Stat_t and syscall.Dirent.
+	ABINative ABI = iota
+	// ABIOneFS7 is the EMC Isilon OneFS 7.x ABI mapped in IsilonStat_t and IsilonDirent.
+	ABIOneFS7
+)
+
+// String returns ABI name.
+func (a ABI) String() string {
+	if a == ABIOneFS7 {
+		return "Isilon OneFS 7.x"
+	}
+	return "native"
+}
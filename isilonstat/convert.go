@@ -0,0 +1,207 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package isilonstat
+
+import (
+	"encoding/binary"
+	"os"
+	"syscall"
+	"time"
+)
+
+// FreeBSD file mode bits. These are not taken from syscall so that conversion
+// from Isilon kernel structures builds and can be tested on every platform.
+const (
+	s_IFMT   = 0o170000
+	s_IFIFO  = 0o010000
+	s_IFCHR  = 0o020000
+	s_IFDIR  = 0o040000
+	s_IFBLK  = 0o060000
+	s_IFREG  = 0o100000
+	s_IFLNK  = 0o120000
+	s_IFSOCK = 0o140000
+	s_ISUID  = 0o004000
+	s_ISGID  = 0o002000
+	s_ISVTX  = 0o001000
+)
+
+// A Timespec is timespec structure from FreeBSD/amd64 kernel.
+type Timespec struct {
+	Sec  int64
+	Nsec int64
+}
+
+// A IsilonStat_t is stat_t structure from Isilon (modified FreeBSD) kernel.
+type IsilonStat_t struct {
+	Dev           uint32
+	Ino           uint64 // FreeBSD7: uint32
+	Mode          uint32 // FreeBSD7: uint16
+	Nlink         uint16
+	Uid           uint32
+	Gid           uint32
+	Rdev          uint32
+	Atimespec     Timespec
+	Mtimespec     Timespec
+	Ctimespec     Timespec
+	Size          int64
+	Blocks        int64
+	Blksize       int32
+	Flags         uint32
+	Gen           uint32
+	Lspare        int32
+	Birthtimespec Timespec
+}
+
+// A IsilonDirent is dirent structure from Isilon (modified FreeBSD) kernel.
+// Name is not fixed size in the getdirentries buffer, record is Reclen long.
+type IsilonDirent struct {
+	Fileno uint64 // FreeBSD7: uint32
+	Reclen uint16
+	Type   uint8
+	Namlen uint8
+	Name   [256]int8
+}
+
+// Offsets of IsilonDirent and syscall.Dirent fields in a getdirentries buffer.
+const (
+	isilonDirentFileno = 0
+	isilonDirentReclen = 8
+	isilonDirentType   = 10
+	isilonDirentNamlen = 11
+	isilonDirentName   = 12
+
+	direntFileno = 0
+	direntReclen = 16
+	direntType   = 18
+	direntNamlen = 20
+	direntName   = 24
+)
+
+// dirBlkSiz is the smallest buffer size getdirentries() accepts.
+const dirBlkSiz = 512
+
+// A fileStat is the implementation of FileInfo returned by Stat and Lstat.
+type fileStat struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+	sys     IsilonStat_t
+}
+
+func (fs *fileStat) Size() int64        { return fs.size }
+func (fs *fileStat) Mode() os.FileMode  { return fs.mode }
+func (fs *fileStat) ModTime() time.Time { return fs.modTime }
+func (fs *fileStat) Sys() interface{}   { return &fs.sys }
+func (fs *fileStat) Name() string       { return fs.name }
+func (fs *fileStat) IsDir() bool        { return fs.Mode().IsDir() }
+
+// convertDirents rewrites IsilonDirent records from src as syscall.Dirent records in dst.
+func convertDirents(dst, src []byte) (n int, err error) {
+	for len(src) > 0 {
+		if len(src) < isilonDirentName {
+			return n, syscall.EIO
+		}
+		reclen := int(binary.LittleEndian.Uint16(src[isilonDirentReclen:]))
+		namlen := int(src[isilonDirentNamlen])
+		if reclen < isilonDirentName+namlen || reclen > len(src) {
+			return n, syscall.EIO
+		}
+
+		// Skip deleted entries
+		fileno := binary.LittleEndian.Uint64(src[isilonDirentFileno:])
+		if fileno != 0 {
+			outlen := (direntName + namlen + 1 + 7) &^ 7
+			if n+outlen > len(dst) {
+				return n, syscall.EINVAL
+			}
+
+			rec := dst[n : n+outlen]
+			for i := range rec {
+				rec[i] = 0
+			}
+			binary.LittleEndian.PutUint64(rec[direntFileno:], fileno)
+			binary.LittleEndian.PutUint16(rec[direntReclen:], uint16(outlen))
+			rec[direntType] = src[isilonDirentType]
+			binary.LittleEndian.PutUint16(rec[direntNamlen:], uint16(namlen))
+			copy(rec[direntName:], src[isilonDirentName:isilonDirentName+namlen])
+			n += outlen
+		}
+
+		src = src[reclen:]
+	}
+
+	return n, nil
+}
+
+func fillFileStatFromSys(fs *fileStat, name string) {
+	fs.name = basename(name)
+	fs.size = fs.sys.Size
+	fs.modTime = timespecToTime(fs.sys.Mtimespec)
+	fs.mode = os.FileMode(fs.sys.Mode & 0777)
+	switch fs.sys.Mode & s_IFMT {
+	case s_IFBLK:
+		fs.mode |= os.ModeDevice
+	case s_IFCHR:
+		fs.mode |= os.ModeDevice | os.ModeCharDevice
+	case s_IFDIR:
+		fs.mode |= os.ModeDir
+	case s_IFIFO:
+		fs.mode |= os.ModeNamedPipe
+	case s_IFLNK:
+		fs.mode |= os.ModeSymlink
+	case s_IFREG:
+		// nothing to do
+	case s_IFSOCK:
+		fs.mode |= os.ModeSocket
+	}
+	if fs.sys.Mode&s_ISGID != 0 {
+		fs.mode |= os.ModeSetgid
+	}
+	if fs.sys.Mode&s_ISUID != 0 {
+		fs.mode |= os.ModeSetuid
+	}
+	if fs.sys.Mode&s_ISVTX != 0 {
+		fs.mode |= os.ModeSticky
+	}
+}
+
+func timespecToTime(ts Timespec) time.Time {
+	return time.Unix(ts.Sec, ts.Nsec)
+}
+
+func basename(name string) string {
+	i := len(name) - 1
+	// Remove trailing slashes
+	for ; i > 0 && name[i] == '/'; i-- {
+		name = name[:i]
+	}
+	// Remove leading directory name
+	for i--; i >= 0; i-- {
+		if name[i] == '/' {
+			name = name[i+1:]
+			break
+		}
+	}
+
+	return name
+}
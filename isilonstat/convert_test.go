@@ -0,0 +1,259 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package isilonstat
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var typeModes = []struct {
+	name string
+	ifmt uint32
+	mode os.FileMode
+}{
+	{"fifo", s_IFIFO, os.ModeNamedPipe},
+	{"chr", s_IFCHR, os.ModeDevice | os.ModeCharDevice},
+	{"dir", s_IFDIR, os.ModeDir},
+	{"blk", s_IFBLK, os.ModeDevice},
+	{"reg", s_IFREG, 0},
+	{"lnk", s_IFLNK, os.ModeSymlink},
+	{"sock", s_IFSOCK, os.ModeSocket},
+}
+
+var specialModes = []struct {
+	name string
+	bits uint32
+	mode os.FileMode
+}{
+	{"none", 0, 0},
+	{"setuid", s_ISUID, os.ModeSetuid},
+	{"setgid", s_ISGID, os.ModeSetgid},
+	{"sticky", s_ISVTX, os.ModeSticky},
+	{"setuid+setgid", s_ISUID | s_ISGID, os.ModeSetuid | os.ModeSetgid},
+	{"setuid+sticky", s_ISUID | s_ISVTX, os.ModeSetuid | os.ModeSticky},
+	{"setgid+sticky", s_ISGID | s_ISVTX, os.ModeSetgid | os.ModeSticky},
+	{"all", s_ISUID | s_ISGID | s_ISVTX, os.ModeSetuid | os.ModeSetgid | os.ModeSticky},
+}
+
+// expectedMode independently maps a FreeBSD st_mode to os.FileMode.
+func expectedMode(mode uint32) os.FileMode {
+	m := os.FileMode(mode).Perm()
+	for _, tm := range typeModes {
+		if mode&s_IFMT == tm.ifmt {
+			m |= tm.mode
+		}
+	}
+	for _, sm := range specialModes[1:4] {
+		if mode&sm.bits != 0 {
+			m |= sm.mode
+		}
+	}
+	return m
+}
+
+func TestFillFileStatFromSysMode(t *testing.T) {
+	for _, tm := range typeModes {
+		for _, sm := range specialModes {
+			for _, perm := range []uint32{0, 0o644, 0o755, 0o777} {
+				var fs fileStat
+				fs.sys.Mode = tm.ifmt | sm.bits | perm
+				fillFileStatFromSys(&fs, "a/b")
+
+				want := tm.mode | sm.mode | os.FileMode(perm)
+				if fs.Mode() != want {
+					t.Errorf("%s %s %#o: Mode() = %v; want %v", tm.name, sm.name, perm, fs.Mode(), want)
+				}
+				if fs.IsDir() != (tm.ifmt == s_IFDIR) {
+					t.Errorf("%s %s %#o: IsDir() = %v", tm.name, sm.name, perm, fs.IsDir())
+				}
+				if fs.Mode().IsRegular() != (tm.ifmt == s_IFREG) {
+					t.Errorf("%s %s %#o: IsRegular() = %v", tm.name, sm.name, perm, fs.Mode().IsRegular())
+				}
+			}
+		}
+	}
+}
+
+func TestFillFileStatFromSys(t *testing.T) {
+	var fs fileStat
+	fs.sys = IsilonStat_t{
+		Mode:      s_IFDIR | 0o755,
+		Size:      4096,
+		Mtimespec: Timespec{Sec: 1536048796, Nsec: 123},
+	}
+	fillFileStatFromSys(&fs, "/ifs/data/")
+
+	if fs.Name() != "data" {
+		t.Errorf("Name() = %q; want %q", fs.Name(), "data")
+	}
+	if fs.Size() != 4096 {
+		t.Errorf("Size() = %v; want 4096", fs.Size())
+	}
+	if want := time.Unix(1536048796, 123); !fs.ModTime().Equal(want) {
+		t.Errorf("ModTime() = %v; want %v", fs.ModTime(), want)
+	}
+	if fs.Sys().(*IsilonStat_t) != &fs.sys {
+		t.Errorf("Sys() does not point to IsilonStat_t")
+	}
+}
+
+func TestTimespecToTime(t *testing.T) {
+	cases := []Timespec{
+		{Sec: 0, Nsec: 0},
+		{Sec: 1, Nsec: 999999999},
+		{Sec: -1, Nsec: 500},
+		{Sec: 1 << 40, Nsec: 1},
+	}
+	for _, ts := range cases {
+		got := timespecToTime(ts)
+		if got.Unix() != ts.Sec || int64(got.Nanosecond()) != ts.Nsec {
+			t.Errorf("timespecToTime(%+v) = %v", ts, got)
+		}
+	}
+}
+
+var basenameCases = []string{
+	"a", "a/", "a//", "/a", "//a//", "a/b", "a/b/", "/a/b/c", "/", "//", "///", "./a", "../", ".", "a/.", "a/..",
+}
+
+func TestBasename(t *testing.T) {
+	skipNonSlash(t)
+
+	for _, name := range basenameCases {
+		if got, want := basename(name), filepath.Base(name); got != want {
+			t.Errorf("basename(%q) = %q; want %q", name, got, want)
+		}
+	}
+}
+
+func TestConvertDirents(t *testing.T) {
+	var src []byte
+	names := []string{"a", "", "deleted", "a-much-longer-file-name.log"}
+	for i, name := range names {
+		reclen := (isilonDirentName + len(name) + 1 + 3) &^ 3
+		rec := make([]byte, reclen)
+		if name != "deleted" {
+			binary.LittleEndian.PutUint64(rec[isilonDirentFileno:], uint64(i+100))
+		}
+		binary.LittleEndian.PutUint16(rec[isilonDirentReclen:], uint16(reclen))
+		rec[isilonDirentType] = uint8(i + 1)
+		rec[isilonDirentNamlen] = uint8(len(name))
+		copy(rec[isilonDirentName:], name)
+		src = append(src, rec...)
+	}
+
+	dst := make([]byte, 3*len(src))
+	n, err := convertDirents(dst, src)
+	if err != nil {
+		t.Fatalf("convertDirents() error = %v", err)
+	}
+
+	var got []string
+	for buf := dst[:n]; len(buf) > 0; {
+		reclen := int(binary.LittleEndian.Uint16(buf[direntReclen:]))
+		namlen := int(binary.LittleEndian.Uint16(buf[direntNamlen:]))
+		if reclen%8 != 0 || reclen < direntName+namlen+1 {
+			t.Fatalf("bad record length %v for name length %v", reclen, namlen)
+		}
+		i := int(binary.LittleEndian.Uint64(buf[direntFileno:])) - 100
+		if buf[direntType] != uint8(i+1) {
+			t.Errorf("entry %v type = %v; want %v", i, buf[direntType], i+1)
+		}
+		got = append(got, string(buf[direntName:direntName+namlen]))
+		buf = buf[reclen:]
+	}
+
+	want := []string{"a", "", "a-much-longer-file-name.log"}
+	if len(got) != len(want) {
+		t.Fatalf("convertDirents() names = %q; want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("convertDirents() names = %q; want %q", got, want)
+		}
+	}
+
+	if _, err := convertDirents(make([]byte, 8), src); err == nil {
+		t.Errorf("convertDirents() into short buffer succeeded")
+	}
+	if _, err := convertDirents(dst, src[:isilonDirentName+1]); err == nil {
+		t.Errorf("convertDirents() of truncated record succeeded")
+	}
+}
+
+func FuzzBasename(f *testing.F) {
+	skipNonSlash(f)
+
+	for _, name := range basenameCases {
+		f.Add(name)
+	}
+	f.Fuzz(func(t *testing.T, name string) {
+		// filepath.Base returns "." for an empty path, stat of an empty path always fails
+		if name == "" {
+			return
+		}
+		if got, want := basename(name), filepath.Base(name); got != want {
+			t.Errorf("basename(%q) = %q; want %q", name, got, want)
+		}
+	})
+}
+
+func FuzzFillFileStatFromSysMode(f *testing.F) {
+	for _, tm := range typeModes {
+		for _, sm := range specialModes {
+			f.Add(tm.ifmt | sm.bits | 0o755)
+		}
+	}
+	f.Add(uint32(0o160000)) // S_IFWHT, no os.FileMode equivalent
+	f.Fuzz(func(t *testing.T, mode uint32) {
+		var fs fileStat
+		fs.sys.Mode = mode
+		fillFileStatFromSys(&fs, "a")
+
+		if want := expectedMode(mode); fs.Mode() != want {
+			t.Errorf("mode %#o: Mode() = %v; want %v", mode, fs.Mode(), want)
+		}
+	})
+}
+
+func FuzzConvertDirents(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{1, 0, 0, 0, 0, 0, 0, 0, 16, 0, 8, 1, 'a', 0, 0, 0})
+	f.Fuzz(func(t *testing.T, src []byte) {
+		dst := make([]byte, 3*len(src))
+		n, _ := convertDirents(dst, src)
+		if n > len(dst) {
+			t.Errorf("convertDirents() = %v, larger than buffer %v", n, len(dst))
+		}
+	})
+}
+
+// skipNonSlash skips tests comparing against filepath where separator is not a slash.
+func skipNonSlash(tb testing.TB) {
+	if filepath.Separator != '/' {
+		tb.Skip("basename is Unix specific")
+	}
+}
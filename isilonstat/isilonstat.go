@@ -19,57 +19,33 @@
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
 // SOFTWARE.
 
+//go:build freebsd && amd64
 // +build freebsd,amd64
 
-// Package isilonstat provides Isilon OneFS 7.1-compatible stat(). This is a
-// fairly naive and incomplete implementation. It might crash and malfunction in
-// most horrible ways.
+// Package isilonstat provides Isilon OneFS 7.x-compatible stat(), fstat(),
+// fstatat() and getdirentries(), together with detection of which kernel ABI
+// is in use. It might crash and malfunction in most horrible ways.
 package isilonstat
 
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 	"syscall"
-	"time"
 	"unsafe"
-)
-
-// A IsilonStat_t is stat_t structure from Isilon (modified FreeBSD) kernel.
-type IsilonStat_t struct {
-	Dev           uint32
-	Ino           uint64 // FreeBSD7: uint32
-	Mode          uint32 // FreeBSD7: uint16
-	Nlink         uint16
-	Uid           uint32
-	Gid           uint32
-	Rdev          uint32
-	Atimespec     syscall.Timespec
-	Mtimespec     syscall.Timespec
-	Ctimespec     syscall.Timespec
-	Size          int64
-	Blocks        int64
-	Blksize       int32
-	Flags         uint32
-	Gen           uint32
-	Lspare        int32
-	Birthtimespec syscall.Timespec
-}
 
-// A fileStat is the implementation of FileInfo returned by Stat and Lstat.
-type fileStat struct {
-	name    string
-	size    int64
-	mode    os.FileMode
-	modTime time.Time
-	sys     IsilonStat_t
-}
+	"golang.org/x/sys/unix"
+)
 
-func (fs *fileStat) Size() int64        { return fs.size }
-func (fs *fileStat) Mode() os.FileMode  { return fs.mode }
-func (fs *fileStat) ModTime() time.Time { return fs.modTime }
-func (fs *fileStat) Sys() interface{}   { return &fs.sys }
-func (fs *fileStat) Name() string       { return fs.name }
-func (fs *fileStat) IsDir() bool        { return fs.Mode().IsDir() }
+// FreeBSD 7 based syscall numbers, long gone from syscall and x/sys/unix.
+const (
+	sysStat          = 188
+	sysFstat         = 189
+	sysLstat         = 190
+	sysGetdirentries = 196
+	sysFstatat       = 493
+)
 
 // Stat returns a FileInfo describing the named file.
 // If there is an error, it will be of type *PathError.
@@ -77,7 +53,7 @@ func Stat(name string) (os.FileInfo, error) {
 	var fs fileStat
 	err := syscallIsilonStat(name, &fs.sys)
 	if err != nil {
-		return nil, &os.PathError{"isilonstat", name, err}
+		return nil, &os.PathError{Op: "isilonstat", Path: name, Err: err}
 	}
 	fillFileStatFromSys(&fs, name)
 	return &fs, nil
@@ -91,12 +67,88 @@ func Lstat(name string) (os.FileInfo, error) {
 	var fs fileStat
 	err := syscallIsilonLstat(name, &fs.sys)
 	if err != nil {
-		return nil, &os.PathError{"isilonlstat", name, err}
+		return nil, &os.PathError{Op: "isilonlstat", Path: name, Err: err}
 	}
 	fillFileStatFromSys(&fs, name)
 	return &fs, nil
 }
 
+// Fstat returns a FileInfo describing the open file f.
+// If there is an error, it will be of type *PathError.
+func Fstat(f *os.File) (os.FileInfo, error) {
+	var fs fileStat
+	err := syscallIsilonFstat(int(f.Fd()), &fs.sys)
+	if err != nil {
+		return nil, &os.PathError{Op: "isilonfstat", Path: f.Name(), Err: err}
+	}
+	fillFileStatFromSys(&fs, f.Name())
+	return &fs, nil
+}
+
+// Fstatat returns a FileInfo describing the named file relative to the directory
+// file descriptor dirfd. Flags can contain unix.AT_SYMLINK_NOFOLLOW.
+// If there is an error, it will be of type *PathError.
+func Fstatat(dirfd int, name string, flags int) (os.FileInfo, error) {
+	var fs fileStat
+	err := syscallIsilonFstatat(dirfd, name, &fs.sys, flags)
+	if err != nil {
+		return nil, &os.PathError{Op: "isilonfstatat", Path: name, Err: err}
+	}
+	fillFileStatFromSys(&fs, name)
+	return &fs, nil
+}
+
+// Getdirentries is a syscall.Getdirentries() replacement for Isilon kernel. It
+// reads IsilonDirent records and returns them converted to syscall.Dirent
+// records, so that it can be used by anything parsing the stock FreeBSD layout.
+func Getdirentries(fd int, buf []byte, basep *uintptr) (n int, err error) {
+	// Converted records grow by at most 3x, so read less to fit them all in buf
+	scratch := make([]byte, (len(buf)/3)&^(dirBlkSiz-1))
+	if len(scratch) < dirBlkSiz {
+		return 0, syscall.EINVAL
+	}
+
+	var base int64
+	m, err := syscallIsilonGetdirentries(fd, scratch, &base)
+	if basep != nil {
+		*basep = uintptr(base)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return convertDirents(buf, scratch[:m])
+}
+
+// DetectABI will identify if the running kernel is Isilon OneFS 7.x. It relies on
+// kern.ostype and kern.osrelease sysctls and confirms the result by checking if
+// IsilonStat_t of the root directory looks sane.
+func DetectABI() ABI {
+	ostype, err := unix.Sysctl("kern.ostype")
+	if err != nil || !strings.Contains(ostype, "OneFS") {
+		return ABINative
+	}
+
+	release, err := unix.Sysctl("kern.osrelease")
+	if err != nil {
+		return ABINative
+	}
+	major, err := strconv.Atoi(strings.SplitN(strings.TrimPrefix(release, "v"), ".", 2)[0])
+	if err != nil || major >= 8 {
+		return ABINative
+	}
+
+	var st IsilonStat_t
+	if err := syscallIsilonStat("/", &st); err != nil {
+		return ABINative
+	}
+	if st.Mode&s_IFMT != s_IFDIR || st.Nlink < 2 {
+		return ABINative
+	}
+
+	return ABIOneFS7
+}
+
 // syscallIsilonStat is an Isilon kernel syscall.Stat() wrapper.
 func syscallIsilonStat(path string, stat *IsilonStat_t) (err error) {
 	var _p0 *byte
@@ -104,7 +156,7 @@ func syscallIsilonStat(path string, stat *IsilonStat_t) (err error) {
 	if err != nil {
 		return
 	}
-	_, _, e1 := syscall.Syscall(syscall.SYS_STAT, uintptr(unsafe.Pointer(_p0)), uintptr(unsafe.Pointer(stat)), 0)
+	_, _, e1 := syscall.Syscall(sysStat, uintptr(unsafe.Pointer(_p0)), uintptr(unsafe.Pointer(stat)), 0)
 	if e1 != 0 {
 		return fmt.Errorf("syscall.SYS_STAT: %s", e1)
 	}
@@ -119,62 +171,43 @@ func syscallIsilonLstat(path string, stat *IsilonStat_t) (err error) {
 	if err != nil {
 		return
 	}
-	_, _, e1 := syscall.Syscall(syscall.SYS_LSTAT, uintptr(unsafe.Pointer(_p0)), uintptr(unsafe.Pointer(stat)), 0)
+	_, _, e1 := syscall.Syscall(sysLstat, uintptr(unsafe.Pointer(_p0)), uintptr(unsafe.Pointer(stat)), 0)
 	if e1 != 0 {
 		return fmt.Errorf("syscall.SYS_LSTAT: %s", e1)
 	}
 	return
 }
 
-func fillFileStatFromSys(fs *fileStat, name string) {
-	fs.name = basename(name)
-	fs.size = fs.sys.Size
-	fs.modTime = timespecToTime(fs.sys.Mtimespec)
-	fs.mode = os.FileMode(fs.sys.Mode & 0777)
-	switch fs.sys.Mode & syscall.S_IFMT {
-	case syscall.S_IFBLK:
-		fs.mode |= os.ModeDevice
-	case syscall.S_IFCHR:
-		fs.mode |= os.ModeDevice | os.ModeCharDevice
-	case syscall.S_IFDIR:
-		fs.mode |= os.ModeDir
-	case syscall.S_IFIFO:
-		fs.mode |= os.ModeNamedPipe
-	case syscall.S_IFLNK:
-		fs.mode |= os.ModeSymlink
-	case syscall.S_IFREG:
-		// nothing to do
-	case syscall.S_IFSOCK:
-		fs.mode |= os.ModeSocket
-	}
-	if fs.sys.Mode&syscall.S_ISGID != 0 {
-		fs.mode |= os.ModeSetgid
-	}
-	if fs.sys.Mode&syscall.S_ISUID != 0 {
-		fs.mode |= os.ModeSetuid
-	}
-	if fs.sys.Mode&syscall.S_ISVTX != 0 {
-		fs.mode |= os.ModeSticky
+// syscallIsilonFstat is an Isilon kernel syscall.Fstat() wrapper.
+func syscallIsilonFstat(fd int, stat *IsilonStat_t) error {
+	_, _, e1 := syscall.Syscall(sysFstat, uintptr(fd), uintptr(unsafe.Pointer(stat)), 0)
+	if e1 != 0 {
+		return fmt.Errorf("syscall.SYS_FSTAT: %s", e1)
 	}
+	return nil
 }
 
-func timespecToTime(ts syscall.Timespec) time.Time {
-	return time.Unix(int64(ts.Sec), int64(ts.Nsec))
-}
-
-func basename(name string) string {
-	i := len(name) - 1
-	// Remove trailing slashes
-	for ; i > 0 && name[i] == '/'; i-- {
-		name = name[:i]
+// syscallIsilonFstatat is an Isilon kernel syscall.Fstatat() wrapper.
+func syscallIsilonFstatat(dirfd int, path string, stat *IsilonStat_t, flags int) (err error) {
+	var _p0 *byte
+	_p0, err = syscall.BytePtrFromString(path)
+	if err != nil {
+		return
 	}
-	// Remove leading directory name
-	for i--; i >= 0; i-- {
-		if name[i] == '/' {
-			name = name[i+1:]
-			break
-		}
+	_, _, e1 := syscall.Syscall6(sysFstatat, uintptr(dirfd), uintptr(unsafe.Pointer(_p0)),
+		uintptr(unsafe.Pointer(stat)), uintptr(flags), 0, 0)
+	if e1 != 0 {
+		return fmt.Errorf("syscall.SYS_FSTATAT: %s", e1)
 	}
+	return
+}
 
-	return name
+// syscallIsilonGetdirentries is an Isilon kernel getdirentries() wrapper.
+func syscallIsilonGetdirentries(fd int, buf []byte, basep *int64) (n int, err error) {
+	r0, _, e1 := syscall.Syscall6(sysGetdirentries, uintptr(fd), uintptr(unsafe.Pointer(&buf[0])),
+		uintptr(len(buf)), uintptr(unsafe.Pointer(basep)), 0, 0)
+	if e1 != 0 {
+		return 0, e1
+	}
+	return int(r0), nil
 }
@@ -19,17 +19,24 @@
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
 // SOFTWARE.
 
+//go:build !freebsd || !amd64
 // +build !freebsd !amd64
 
-// Package isilonstat provides Isilon OneFS 7.1-compatible stat(). This is a
-// fairly naive and incomplete implementation. It might crash and malfunction in
-// most horrible ways.
+// Package isilonstat provides Isilon OneFS 7.x-compatible stat(), fstat(),
+// fstatat() and getdirentries(), together with detection of which kernel ABI
+// is in use. It might crash and malfunction in most horrible ways.
 package isilonstat
 
 import (
+	"errors"
 	"os"
 )
 
+// DetectABI always returns ABINative as Isilon kernel is FreeBSD/amd64.
+func DetectABI() ABI {
+	return ABINative
+}
+
 // Stat is just a dummy wrapper for os.Stat().
 func Stat(name string) (os.FileInfo, error) {
 	return os.Stat(name)
@@ -39,3 +46,13 @@ func Stat(name string) (os.FileInfo, error) {
 func Lstat(name string) (os.FileInfo, error) {
 	return os.Lstat(name)
 }
+
+// Fstat is just a dummy wrapper for os.File.Stat().
+func Fstat(f *os.File) (os.FileInfo, error) {
+	return f.Stat()
+}
+
+// Fstatat is only implemented for Isilon kernel and always fails.
+func Fstatat(dirfd int, name string, flags int) (os.FileInfo, error) {
+	return nil, &os.PathError{Op: "isilonfstatat", Path: name, Err: errors.New("not implemented")}
+}
@@ -0,0 +1,151 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// walkLimits limits a single directory tree walk and keeps track of how much of the tree was covered.
+type walkLimits struct {
+	maxDuration time.Duration
+	maxDepth    int
+	maxDirs     int64
+
+	start time.Time
+	// checked is the number of directories checked
+	checked int64
+	// found is the number of directories known to exist from st_nlink, including unchecked ones
+	found int64
+	// reason is why the walk is incomplete, empty if it is not
+	reason string
+	// stop is set when the walk is to be stopped from another goroutine
+	stop int32
+	// stopReason is why the walk was requested to stop
+	stopReason string
+	stopMutex  sync.Mutex
+}
+
+// newWalkLimits returns limits for a walk starting now, zero values mean no limit.
+func newWalkLimits(maxDuration time.Duration, maxDepth int, maxDirs int64) *walkLimits {
+	return &walkLimits{maxDuration: maxDuration, maxDepth: maxDepth, maxDirs: maxDirs, start: time.Now(), found: 1}
+}
+
+// check will count a directory as checked, or return errStopWalk if time or directory limit has been reached.
+func (l *walkLimits) check() error {
+	if l.stopRequested() {
+		l.stopMutex.Lock()
+		l.reason = l.stopReason
+		l.stopMutex.Unlock()
+		return errStopWalk
+	}
+	if l.maxDirs > 0 && l.checked >= l.maxDirs {
+		l.reason = fmt.Sprintf("directory limit of %v was reached", l.maxDirs)
+		return errStopWalk
+	}
+	if l.maxDuration > 0 && time.Since(l.start) >= l.maxDuration {
+		l.reason = fmt.Sprintf("time limit of %v was reached", l.maxDuration)
+		return errStopWalk
+	}
+
+	l.checked++
+	return nil
+}
+
+// requestStop will make the walk stop at the next directory, reporting reason as why it is incomplete. Only the first
+// reason is kept.
+func (l *walkLimits) requestStop(reason string) {
+	l.stopMutex.Lock()
+	if l.stopReason == "" {
+		l.stopReason = reason
+	}
+	l.stopMutex.Unlock()
+	atomic.StoreInt32(&l.stop, 1)
+}
+
+// stopRequested checks if the walk is to be stopped.
+func (l *walkLimits) stopRequested() bool {
+	return atomic.LoadInt32(&l.stop) != 0
+}
+
+// descend will return filepath.SkipDir for directories at depth limit, and count subdirectories of the directory as
+// found otherwise.
+func (l *walkLimits) descend(depth int, subdirs int64, subdirsKnown bool) error {
+	l.found += subdirs
+
+	if l.maxDepth > 0 && depth >= l.maxDepth {
+		if (!subdirsKnown || subdirs > 0) && l.reason == "" {
+			l.reason = fmt.Sprintf("depth limit of %v was reached", l.maxDepth)
+		}
+		return filepath.SkipDir
+	}
+
+	return nil
+}
+
+// descendOffender will return filepath.SkipDir for a large directory o, unless descendOffenders is set and it may have
+// subdirectories to walk. Large directories are walked only for their subdirectories, which walk engines find by d_type
+// without stat of each entry. With st_nlink of 2 there are no subdirectories and nothing to walk.
+func (l *walkLimits) descendOffender(depth int, o *offender, descendOffenders bool) error {
+	if descendOffenders && o.unreadable == nil && (!o.subdirsKnown || o.subdirs > 0) {
+		return l.descend(depth, o.subdirs, o.subdirsKnown)
+	}
+
+	return filepath.SkipDir
+}
+
+// report will log that the walk is incomplete, and how much of the tree was covered.
+func (l *walkLimits) report(rootPath string, nlinkUsable bool) {
+	if l.reason == "" {
+		return
+	}
+
+	elapsed := time.Since(l.start).Round(time.Second)
+	if nlinkUsable {
+		log.Printf("Scan of %q is incomplete as %v: checked %v directories in %v, %.1f%% of directories found so far.",
+			rootPath, l.reason, l.checked, elapsed, 100*float64(l.checked)/float64(l.found))
+	} else {
+		log.Printf("Scan of %q is incomplete as %v: checked %v directories in %v.", rootPath, l.reason, l.checked,
+			elapsed)
+	}
+}
+
+// pathDepth returns depth of osPathname in a tree rooted at rootPath, root being at depth 0.
+func pathDepth(rootPath, osPathname string) int {
+	rel := strings.Trim(strings.TrimPrefix(osPathname, rootPath), string(os.PathSeparator))
+	if rel == "" {
+		return 0
+	}
+	return strings.Count(rel, string(os.PathSeparator)) + 1
+}
+
+// isOutside checks if relative pathname leads outside of its base directory.
+func isOutside(rel string) bool {
+	return rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator))
+}
@@ -0,0 +1,66 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDescendOffender(t *testing.T) {
+	unreadable := &os.PathError{Op: "open", Path: "/large", Err: os.ErrPermission}
+
+	tests := []struct {
+		name             string
+		offender         offender
+		descendOffenders bool
+		maxDepth         int
+		want             error
+		wantFound        int64
+		wantReason       bool
+	}{
+		{"default", offender{subdirs: 3, subdirsKnown: true}, false, 0, filepath.SkipDir, 1, false},
+		{"subdirs", offender{subdirs: 3, subdirsKnown: true}, true, 0, nil, 4, false},
+		{"unknown subdirs", offender{}, true, 0, nil, 1, false},
+		{"no subdirs", offender{subdirsKnown: true}, true, 0, filepath.SkipDir, 1, false},
+		{"unreadable", offender{subdirs: 3, subdirsKnown: true, unreadable: unreadable}, true, 0, filepath.SkipDir, 1,
+			false},
+		{"depth limit", offender{subdirs: 3, subdirsKnown: true}, true, 2, filepath.SkipDir, 4, true},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			l := newWalkLimits(0, tc.maxDepth, 0)
+			if got := l.descendOffender(2, &tc.offender, tc.descendOffenders); got != tc.want {
+				t.Errorf("descendOffender() = %v; want %v", got, tc.want)
+			}
+			if l.found != tc.wantFound {
+				t.Errorf("found = %v; want %v", l.found, tc.wantFound)
+			}
+			if (l.reason != "") != tc.wantReason {
+				t.Errorf("reason = %q; want set %v", l.reason, tc.wantReason)
+			}
+		})
+	}
+}
@@ -22,37 +22,117 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"github.com/karrick/godirwalk"
 	"github.com/pborman/getopt/v2"
 	"log"
 	"math"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const testDirName = "findlargedir"
 const defaultAlertThreshold = 50000
+const defaultSubdirThreshold = 50000
 const defaultTestFileCount = 20000
 const defaultProgressTicker = time.Minute * 5
 const defaultPathnameQueueSize = 1024
+const defaultQueueDepth = 64
+const exitWalkErrors = 2
+
+// Filesystem boundary modes
+const (
+	boundaryMount      = "mount"
+	boundaryFilesystem = "filesystem"
+	boundaryPool       = "pool"
+)
+
+// I/O scheduling classes
+const (
+	ioClassNone       = "none"
+	ioClassIdle       = "idle"
+	ioClassBestEffort = "best-effort"
+)
 
-var alertThreshold, testFileCount *int64
-var helpFlag, accurateFlag, progressFlag, isilonFlag, cloexecFlag, oneFilesystemFlag *bool
+var alertThreshold, subdirThreshold, testFileCount *int64
+var queueDepth, nameLength, verifySampleSize, maxDepth, ioLevel, niceValue *int
+var maxDirs, maxOps *int64
+var maxDuration, stallTimeout *time.Duration
+var maxIOPressure, maxCPUPressure, maxLoad float64
+var helpFlag, accurateFlag, progressFlag, isilonFlag, cloexecFlag, oneFilesystemFlag, noSyncFlag, schedIdleFlag *bool
+var jsonFlag, failOnErrorsFlag, descendOffendersFlag, resumeFlag, followSymlinksFlag, promptCleanupFlag *bool
+var checkpointFile, benchFile *string
+var engineName, thresholdMode, boundaryMode, ioClass, stallAction *string
+var engine walkEngine
+var state *checkpoint
+var mounts *mountTable
+var sharedDevs map[uint64]bool
+var visited = visitedDirs{}
+var metadataThrottle *opsThrottle
+var benchResults benchReports
 
 func init() {
 	alertThreshold = getopt.Int64Long("threshold", 't', defaultAlertThreshold,
 		fmt.Sprintf("set file count threshold for alerting (default %v)", defaultAlertThreshold))
+	subdirThreshold = getopt.Int64Long("subdirthreshold", 's', defaultSubdirThreshold,
+		fmt.Sprintf("set subdirectory count threshold for alerting (default %v)", defaultSubdirThreshold))
 	testFileCount = getopt.Int64Long("testcount", 'c', defaultTestFileCount,
 		fmt.Sprintf("set initial file count for inode size testing phase (default %v)", defaultTestFileCount))
 	helpFlag = getopt.BoolLong("help", 'h', "display help")
 	accurateFlag = getopt.BoolLong("accurate", 'a', "full accuracy when checking large directories")
 	progressFlag = getopt.BoolLong("progress", 'p', "display progress status every 5 minutes")
-	isilonFlag = getopt.BoolLong("isilon", '7', "enable support for EMC Isilon OneFS 7.x")
+	isilonFlag = getopt.BoolLong("isilon", '7', "force support for EMC Isilon OneFS 7.x (autodetected)")
 	cloexecFlag = getopt.BoolLong("cloexec", 'x', "disable open O_CLOEXEC for really ancient Unix systems")
 	oneFilesystemFlag = getopt.BoolLong("onefilesystem", 'o', "never cross filesystem boundaries")
+	boundaryMode = getopt.EnumLong("boundary", 0, []string{boundaryMount, boundaryFilesystem, boundaryPool},
+		boundaryMount, "stop at mount points, filesystems (btrfs subvolumes included) or pools (ZFS, LVM), implies -o (default mount)")
+	noSyncFlag = getopt.BoolLong("nosync", 'n', "don't synchronise attributes with network filesystem servers (Linux only)")
+	engineName = getopt.StringLong("engine", 'e', defaultWalkEngine,
+		fmt.Sprintf("set directory walk engine: fd, uring, bestfirst or godirwalk (default %v)", defaultWalkEngine))
+	queueDepth = getopt.IntLong("queuedepth", 'q', defaultQueueDepth,
+		fmt.Sprintf("set io_uring queue depth for uring walk engine (default %v)", defaultQueueDepth))
+	descendOffendersFlag = getopt.BoolLong("descend-offenders", 0, "keep walking subdirectories of large directories")
+	followSymlinksFlag = getopt.BoolLong("follow-symlinks", 0,
+		"follow symlinks to directories, checking each directory only once")
+	nameLength = getopt.IntLong("name-length", 'l', 0,
+		"set typical file name length for estimates (default sampled for each large directory)")
+	thresholdMode = getopt.EnumLong("threshold-mode", 'm', []string{thresholdBest, thresholdPossible, thresholdDefinite},
+		thresholdBest, "set threshold mode: best estimate, possible (upper bound) or definite (lower bound) (default best)")
+	verifySampleSize = getopt.IntLong("verify-sample", 0, 0,
+		"exact-count a random sample of N large and near-threshold directories to verify and refine estimates")
+	maxDuration = getopt.DurationLong("max-duration", 0, 0, "stop walking each directory after given time (default no limit)")
+	maxDepth = getopt.IntLong("max-depth", 0, 0, "don't walk deeper than given depth (default no limit)")
+	maxDirs = getopt.Int64Long("max-dirs", 0, 0, "stop walking each directory after checking given number of directories (default no limit)")
+	stallTimeout = getopt.DurationLong("stall-timeout", 0, 0,
+		"give up on metadata calls not finished in given time, as on hung network filesystems (default no limit)")
+	stallAction = getopt.EnumLong("stall-action", 0, []string{stallSkip, stallAbort}, stallSkip,
+		"on stalled calls skip the directory or abort walking its root directory (default skip)")
+	checkpointFile = getopt.StringLong("checkpoint", 0, "", "periodically save walk state to given file")
+	resumeFlag = getopt.BoolLong("resume", 0, "resume interrupted scans from checkpoint file")
+	benchFile = getopt.StringLong("bench-file", 0, "",
+		"save benchmark results to given file, or use them to estimate listing times when scanning")
+	ioClass = getopt.EnumLong("io-class", 0, []string{ioClassNone, ioClassIdle, ioClassBestEffort}, ioClassNone,
+		"set I/O scheduling class: none, idle or best-effort (Linux only, default none)")
+	ioLevel = getopt.IntLong("io-level", 0, 4, "set best-effort I/O priority level from 0 (highest) to 7 (lowest) (default 4)")
+	niceValue = getopt.IntLong("nice", 0, 0, "set nice value from -20 to 19 (Linux only, default 0)")
+	schedIdleFlag = getopt.BoolLong("sched-idle", 0, "run with SCHED_IDLE scheduling policy (Linux only)")
+	maxOps = getopt.Int64Long("max-ops", 0, 0,
+		"limit metadata operations per second of walking, calibration, accurate mode and benchmark (default no limit)")
+	getopt.FlagLong(&maxIOPressure, "max-io-pressure", 0,
+		"slow down and pause when I/O pressure stall percentage exceeds given value (Linux only, default no limit)")
+	getopt.FlagLong(&maxCPUPressure, "max-cpu-pressure", 0,
+		"slow down and pause when CPU pressure stall percentage exceeds given value (Linux only, default no limit)")
+	getopt.FlagLong(&maxLoad, "max-load", 0,
+		"slow down and pause when 1 minute load average exceeds given value (Linux only, default no limit)")
+	promptCleanupFlag = getopt.BoolLong("prompt-cleanup", 0,
+		"ask to remove stale calibration directories found while scanning, if running interactively")
+	getopt.SetParameters("[cleanup | bench] directory ...")
+	jsonFlag = getopt.BoolLong("json", 'j', "write JSON report for each directory to standard output")
+	failOnErrorsFlag = getopt.BoolLong("fail-on-errors", 0,
+		fmt.Sprintf("exit with status %v if any errors were encountered", exitWalkErrors))
 }
 
 func main() {
@@ -64,40 +144,257 @@ func main() {
 		os.Exit(0)
 	}
 
+	// Choosing filesystem boundary implies not crossing it
+	if getopt.IsSet("boundary") {
+		*oneFilesystemFlag = true
+	}
+
+	// Lower priorities and pace metadata operations to keep the scan from hurting other workloads
+	if *ioLevel < 0 || *ioLevel > 7 {
+		log.Fatalf("Invalid I/O priority level %v, it has to be between 0 and 7.", *ioLevel)
+	}
+	if *niceValue < -20 || *niceValue > 19 {
+		log.Fatalf("Invalid nice value %v, it has to be between -20 and 19.", *niceValue)
+	}
+	if err := setPriority(*ioClass, *ioLevel, *niceValue, *schedIdleFlag); err != nil {
+		log.Fatalf("Unable to set scan priority: %v.", err)
+	}
+	pressure, err := newPressureMonitor(pressureLimits{io: maxIOPressure, cpu: maxCPUPressure, load: maxLoad})
+	if err != nil {
+		log.Fatalf("Unable to monitor system pressure: %v.", err)
+	}
+	if pressure != nil {
+		go pressure.run()
+	}
+	metadataThrottle = newOpsThrottle(*maxOps, pressure)
+
+	// Remove calibration directories left behind by killed processes
+	if args[0] == "cleanup" {
+		if len(args) < 2 {
+			log.Fatal("Cleanup requires at least one directory.")
+		}
+		os.Exit(runCleanup(args[1:]))
+	}
+
+	// Benchmark metadata operations instead of scanning
+	if args[0] == "bench" {
+		if len(args) < 2 {
+			log.Fatal("Benchmark requires at least one directory.")
+		}
+		os.Exit(runBench(args[1:]))
+	}
+
+	// Estimate listing times from earlier benchmark results
+	if *benchFile != "" {
+		if benchResults, err = loadBenchReports(*benchFile); err != nil {
+			log.Fatalf("Unable to load benchmark results: %v.", err)
+		}
+	}
+
 	log.Printf("Note: program will attempt to identify directories larger than %v entries. Make sure you have r/w privileges.",
 		*alertThreshold)
 
 	// If Unix system doesn't support open O_CLOEXEC, try monkey patching syscall.Open
 	// This will work only on FreeBSD and derivatives
 	if *cloexecFlag {
-		patchSyscallOpen()
+		if err := patchSyscallOpen(); err != nil {
+			log.Fatalf("Unable to use cloexec mode: %v.", err)
+		}
 	}
 
-	// If using EMC Isilon 7.x compatibility, try monkey patching syscall.Stat and syscall.Lstat
+	// If using EMC Isilon 7.x compatibility, try monkey patching syscall.Stat, syscall.Lstat and
+	// syscall.Getdirentries. Isilon 7.x kernel is detected automatically, isilonFlag forces it
+	isilonDetected := !*isilonFlag && detectIsilonStat()
+	if isilonDetected {
+		log.Print("Detected EMC Isilon OneFS 7.x kernel, enabling isilon mode.")
+		*isilonFlag = true
+	}
 	if *isilonFlag {
-		patchSyscallStat()
-		patchSyscallLstat()
+		if err := canPatchSyscallStat(); err != nil {
+			// Only isilon mode forced by the user is required to work
+			if !isilonDetected {
+				log.Fatalf("Unable to use isilon mode: %v.", err)
+			}
+			log.Printf("Warning: unable to use detected isilon mode (%v), continuing without it.", err)
+			*isilonFlag = false
+		} else {
+			patchSyscallStat()
+			patchSyscallLstat()
+			patchSyscallGetdirentries()
+		}
+	}
+
+	// Stall watchdog guards single metadata calls, which uring and godirwalk engines don't make one by one
+	if *stallTimeout > 0 && (*engineName == "uring" || *engineName == "godirwalk") {
+		if getopt.IsSet("engine") {
+			log.Fatalf("Stall timeout can't be used with %v walk engine, use fd or bestfirst engine instead.",
+				*engineName)
+		}
+		*engineName = "bestfirst"
+	}
+
+	// Isilon and cloexec modes patch only syscalls used by godirwalk and os.Stat, as used by bestfirst engine too
+	if (*isilonFlag || *cloexecFlag) && *engineName != "godirwalk" && *engineName != "bestfirst" {
+		compatible := "godirwalk"
+		if *stallTimeout > 0 {
+			compatible = "bestfirst"
+		}
+		log.Printf("Switching from %v to %v walk engine due to isilon or cloexec mode.", *engineName, compatible)
+		*engineName = compatible
 	}
 
+	if engine, err = getWalkEngine(*engineName); err != nil {
+		log.Fatalf("Unable to walk directories: %v.", err)
+	}
+
+	// Load walk state of interrupted scans, or start saving a new one
+	if *resumeFlag && *checkpointFile == "" {
+		log.Fatal("Resuming requires a checkpoint file.")
+	}
+	if *checkpointFile != "" {
+		state = newCheckpoint()
+		if *resumeFlag {
+			if state, err = loadCheckpoint(*checkpointFile); err != nil {
+				log.Fatalf("Unable to resume: %v.", err)
+			}
+		}
+	}
+
+	// Directories on devices mounted more than once, as with bind mounts, can be reached through several paths
+	if mounts, err = loadMounts(); err != nil {
+		log.Printf("Unable to read mount table, bind mounts will not be detected: %v.", err)
+	} else if mounts != nil {
+		sharedDevs = mounts.sharedDevices()
+	}
+
+	roots := make([]string, 0, len(args))
 	for i := range args {
-		processDirectory(filepath.Clean(args[i]))
+		roots = append(roots, filepath.Clean(args[i]))
+	}
+
+	var errorTotal int64
+	for _, rootPath := range dedupeRoots(roots) {
+		report := processDirectory(rootPath)
+		errorTotal += report.ErrorTotal
+
+		if *jsonFlag {
+			if err := report.write(); err != nil {
+				log.Fatalf("Unable to write JSON report: %v.", err)
+			}
+		}
+	}
+
+	if *failOnErrorsFlag && errorTotal > 0 {
+		log.Printf("Exiting with error status as %v errors were encountered.", errorTotal)
+		os.Exit(exitWalkErrors)
 	}
 }
 
 // processDirectory will process individual root filesystem/folder path and identify blackhole directory offenders.
-func processDirectory(rootPath string) {
-	// Establish file to directory inode ratio
-	ratio := getInodeRatio(rootPath)
-	if ratio <= 0 {
-		log.Printf("Unable to calculate inode to file count ratio on %q. Skipping.", rootPath)
-		return
-	}
+func processDirectory(rootPath string) *rootReport {
+	var offenders []*offender
+	walkErrs := newWalkErrors(defaultErrorPaths)
+	throttledStart := metadataThrottle.throttledTime()
 
 	// Save root stat info for later use
-	rootStat, err := os.Lstat(rootPath)
+	rootStat, err := statPath(rootPath, *followSymlinksFlag)
 	if err != nil {
 		log.Print(err)
-		return
+		walkErrs.add(rootPath, err)
+		return newRootReport(rootPath, offenders, walkErrs)
+	}
+
+	// Skip root directory already checked as a part of another one
+	if trackVisited(rootStat) && visited.has(rootStat) {
+		log.Printf("Directory %q was already checked through another path, skipping.", rootPath)
+		return newRootReport(rootPath, offenders, walkErrs)
+	}
+
+	// Look for calibration directories left behind by killed processes
+	checkCalibrationDirs(rootPath)
+
+	// Resume from checkpoint if root is still the same directory
+	var resumed *rootCheckpoint
+	if *resumeFlag {
+		if resumed, err = state.resumable(rootPath, rootStat); err != nil {
+			log.Printf("Unable to resume scan of %q, starting over: %v.", rootPath, err)
+		}
+	}
+	if resumed != nil && resumed.Complete {
+		log.Printf("Scan of %q is already complete according to checkpoint, found %v large directories.", rootPath,
+			len(resumed.Offenders))
+		report := newRootReport(rootPath, offenders, walkErrs)
+		report.Offenders = append(report.Offenders, resumed.Offenders...)
+		report.DirsChecked = resumed.DirsChecked
+		return report
+	}
+
+	var ratio inodeRatio
+	var nlinkUsable bool
+	var names nameSampler
+	limits := newWalkLimits(*maxDuration, *maxDepth, *maxDirs)
+	completed := completedDirs{}
+	var savedOffenders []offenderReport
+	restoredOffenders := map[string]bool{}
+
+	if resumed != nil {
+		// Restore calibration and walk state, restored offenders are not reported again
+		log.Printf("Resuming scan of %q from checkpoint saved at %v.", rootPath, resumed.Updated.Format(time.RFC3339))
+		ratio, nlinkUsable = resumed.Ratio.restore(), resumed.NlinkUsable
+		names = nameSampler{count: resumed.NameCount, total: resumed.NameTotal}
+		limits.checked = resumed.DirsChecked
+		completed = newCompletedDirs(resumed.Completed)
+		savedOffenders = append(savedOffenders, resumed.Offenders...)
+		for _, o := range resumed.Offenders {
+			restoredOffenders[o.Path] = true
+		}
+	} else {
+		// Establish file to directory inode ratio
+		ratio = getInodeRatio(rootPath)
+		if !ratio.valid() {
+			log.Printf("Unable to calculate inode to file count ratio on %q. Skipping.", rootPath)
+			walkErrs.add(rootPath, errors.New("unable to calculate inode ratio"))
+			return newRootReport(rootPath, offenders, walkErrs)
+		}
+
+		// Check if subdirectory count can be estimated from st_nlink
+		nlinkUsable = checkNlink(rootPath)
+	}
+	if benchRoot, rate := benchResults.readdirRate(rootPath); rate > 0 {
+		log.Printf("Using directory listing rate of %.0f entries/s benchmarked on %q to estimate listing times.", rate,
+			benchRoot)
+		ratio.readdirRate = rate
+	}
+	if !nlinkUsable {
+		log.Printf("Directory st_nlink on %q does not count subdirectories, subdirectory counts will not be estimated.",
+			rootPath)
+	}
+
+	// Save walk state to checkpoint file
+	lastSave := time.Now()
+	saveCheckpoint := func() {
+		if state == nil {
+			return
+		}
+
+		state.Roots[rootPath] = &rootCheckpoint{
+			Root:        rootPath,
+			Dev:         rootStat.dev,
+			Ino:         rootStat.ino,
+			Ratio:       saveRatio(ratio),
+			NlinkUsable: nlinkUsable,
+			NameCount:   names.count,
+			NameTotal:   names.total,
+			DirsChecked: limits.checked,
+			Completed:   completed.list(),
+			Offenders:   savedOffenders,
+			Complete:    completed.has(rootPath),
+			Updated:     time.Now(),
+		}
+		if err := state.save(*checkpointFile); err != nil {
+			log.Printf("Unable to save checkpoint: %v.", err)
+		}
+		lastSave = time.Now()
 	}
 
 	// Common Goroutine variables
@@ -109,6 +406,8 @@ func processDirectory(rootPath string) {
 	signalTermChan := make(chan os.Signal, 1)
 	doneSignalChan := make(chan struct{}, 1)
 	defer close(doneSignalChan)
+	// terminating is set once the walk is stopped on SIGTERM to save checkpoint
+	var terminating int32
 
 	// Signal handler goroutine: handle SIGUSR1, SIGUSR2 and SIGTERM
 	registerStatusSignal(signalChan, signalTermChan)
@@ -122,8 +421,13 @@ func processDirectory(rootPath string) {
 				// SIGUSR1, SIGUSR2: display progress update and resume
 				printPath(lastPathname)
 			case <-signalTermChan:
-				// SIGTERM: display progress update and exit with error
+				// SIGTERM: display progress update and exit with error, saving checkpoint first if needed
 				printPath(lastPathname)
+				if state != nil && atomic.CompareAndSwapInt32(&terminating, 0, 1) {
+					log.Printf("Stopping walk to save checkpoint, please wait...")
+					limits.requestStop("walk was stopped by a signal")
+					continue
+				}
 				log.Printf("Exiting program as requested.")
 				os.Exit(1)
 			case <-doneSignalChan:
@@ -158,7 +462,7 @@ func processDirectory(rootPath string) {
 	}
 
 	// Deep-dive directory counting goroutine variables
-	accurateChan := make(chan string, defaultPathnameQueueSize)
+	accurateChan := make(chan *offender, defaultPathnameQueueSize)
 
 	// Async large-directory accurate counting
 	if *accurateFlag {
@@ -166,61 +470,165 @@ func processDirectory(rootPath string) {
 		go func() {
 			defer wg.Done()
 
-			for v := range accurateChan {
-				deChildren, err := godirwalk.ReadDirents(v, nil)
+			for o := range accurateChan {
+				count, err := engine.countEntries(o.pathname)
 				if err != nil {
 					log.Print(err)
+					walkErrs.add(o.pathname, err)
 					continue
 				}
+				o.exact, o.exactKnown = count, true
 
-				log.Printf("Correct enumeration: directory %q has exactly %v entries.", v, len(deChildren))
+				log.Printf("Correct enumeration: directory %q has exactly %v entries (%v files, %v subdirectories).",
+					o.pathname, count.entries, count.entries-count.subdirs, count.subdirs)
 			}
 		}()
 	}
 
-	var offenderTotal, countFromStat int64
+	// Async verification of a sample of estimates
+	var v *verifier
+	if *verifySampleSize > 0 {
+		v = newVerifier(*verifySampleSize)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v.run(engine.countEntries)
+		}()
+	}
 
-	// Fast concurrent directory walker: won't follow symlinks and won't sort entries
-	_ = godirwalk.Walk(rootPath, &godirwalk.Options{
-		Unsorted:            true,
-		FollowSymbolicLinks: false,
-		// Default callback will process only directory entries
-		Callback: func(osPathname string, de *godirwalk.Dirent) error {
-			// Process only if entry is directory
-			if de.IsDir() {
-				lastPathname = &osPathname
-				fi, err := os.Stat(osPathname)
-				if err != nil {
-					return err
-				}
+	var unreadableTotal int64
 
-				// Check if we are crossing filesystem boundaries
-				if *oneFilesystemFlag && !isSameFilesystem(rootStat, fi) {
-					log.Printf("Directory %q is a mount point, skipping further checks.", osPathname)
-					return fmt.Errorf("directory %q is a mount point", osPathname)
+	var boundary *filesystemBoundary
+	if *oneFilesystemFlag {
+		boundary = newFilesystemBoundary(*boundaryMode, rootPath, rootStat)
+	}
+
+	// Walk directories, following symlinks only if requested, checking each directory size
+	err = engine.walk(rootPath, &walkOptions{
+		callback: func(osPathname string, di *dirInfo) error {
+			lastPathname = &osPathname
+
+			// Skip directories walked before resuming
+			if completed.has(osPathname) {
+				return filepath.SkipDir
+			}
+
+			// Skip symlink loops and directories already checked through another path
+			if trackVisited(di) && !visited.visit(di) {
+				log.Printf("Directory %q was already checked through another path, skipping.", osPathname)
+				return filepath.SkipDir
+			}
+
+			// Stop walking if time or directory limit has been reached
+			if err := limits.check(); err != nil {
+				return err
+			}
+
+			// Periodically save walk state
+			if state != nil && time.Since(lastSave) >= defaultCheckpointInterval {
+				saveCheckpoint()
+			}
+
+			// Check if we are crossing filesystem boundaries
+			if boundary != nil && boundary.crosses(osPathname, di) {
+				log.Printf("Directory %q %v, skipping further checks.", osPathname, boundary.describe())
+				return filepath.SkipDir
+			}
+
+			// Continue with approximate checking
+			// Use ratio refined by verified samples so far
+			current := ratio
+			if v != nil {
+				current = ratio.scaled(v.factor())
+			}
+			// Apply ratio for the given or sampled file name length
+			var meanNameLength float64
+			if *nameLength > 0 {
+				meanNameLength = float64(*nameLength)
+			} else {
+				meanNameLength = dirNameLength(osPathname, di.size, current,
+					int64(nearThresholdFraction*float64(*alertThreshold)), names.mean())
+			}
+			entries := current.estimate(di.size, meanNameLength)
+			subdirs, subdirsKnown := estimateSubdirs(di, nlinkUsable)
+			entries = entries.atLeast(subdirs)
+
+			o := &offender{
+				pathname:       osPathname,
+				entries:        entries,
+				subdirs:        subdirs,
+				subdirsKnown:   subdirsKnown,
+				tooManyEntries: entries.value(*thresholdMode) >= *alertThreshold,
+				tooManySubdirs: subdirsKnown && subdirs >= *subdirThreshold,
+				listingTime:    ratio.listingTime(entries.best),
+			}
+			flagged := o.tooManyEntries || o.tooManySubdirs
+			if flagged {
+				o.unreadable = checkReadable(osPathname)
+				if *followSymlinksFlag {
+					o.resolved = resolvePath(osPathname)
 				}
+				if mounts != nil {
+					o.canonical = mounts.canonicalPath(o.realPath(), di)
+				}
+			}
+
+			// Offer large and near-threshold directories for verification
+			if v != nil && o.unreadable == nil &&
+				(flagged || float64(entries.best) >= nearThresholdFraction*float64(*alertThreshold)) {
+				v.offer(verifyCandidate{
+					pathname:   osPathname,
+					estimate:   entries.best,
+					excess:     float64(di.size) - ratio.intercept(),
+					modelRatio: ratio.at(meanNameLength),
+				})
+			}
 
-				// Continue with approximate checking
-				countFromStat = int64(float64(fi.Size()) / ratio)
-				if countFromStat >= int64(*alertThreshold) {
-					log.Printf("Directory %q is possibly a large directory with %v entries.", osPathname,
-						humanPrint(countFromStat))
-					offenderTotal++
+			if flagged && restoredOffenders[osPathname] {
+				// Already reported before resuming, walk it the same way again
+				return limits.descendOffender(pathDepth(rootPath, osPathname), o, *descendOffendersFlag)
+			}
+
+			if flagged {
+				// Directory stat works even without read permission, so report it either way
+				o.report()
+				offenders = append(offenders, o)
+				if o.unreadable != nil {
+					unreadableTotal++
+				}
+				if state != nil {
+					savedOffenders = append(savedOffenders, o.toReport())
+				}
 
-					// If necessary deep-dive the directory and get accurate file count
-					if *accurateFlag {
-						accurateChan <- osPathname
+				// If necessary deep-dive the directory and get accurate file count
+				if *accurateFlag {
+					if o.unreadable != nil {
+						log.Printf("Skipping accurate enumeration of directory %q as it is unreadable (%v).", osPathname,
+							unwrapPathError(o.unreadable))
+					} else {
+						accurateChan <- o
 					}
-					return fmt.Errorf("directory %q is too large to process", osPathname)
 				}
+
+				return limits.descendOffender(pathDepth(rootPath, osPathname), o, *descendOffendersFlag)
 			}
-			return nil
+			return limits.descend(pathDepth(rootPath, osPathname), subdirs, subdirsKnown)
 		},
-		// Default error callback will just skip over when encountering errors
-		ErrorCallback: func(osPathname string, err error) godirwalk.ErrorAction {
-			return godirwalk.SkipNode
+		// Record errors and skip over, they are summarised when done. Stalled calls can also stop the walk
+		errorCallback: func(osPathname string, err error) {
+			walkErrs.add(osPathname, err)
+			if *stallAction == stallAbort && errors.Is(err, errStall) {
+				limits.requestStop(fmt.Sprintf("a metadata call stalled on %q", osPathname))
+			}
 		},
+		nameCallback:   names.add,
+		doneCallback:   completed.add,
+		followSymlinks: *followSymlinksFlag,
 	})
+	if err != nil {
+		log.Print(err)
+		walkErrs.add(rootPath, err)
+	}
 
 	// Close channels and cleanup routines
 	if *progressFlag {
@@ -228,9 +636,67 @@ func processDirectory(rootPath string) {
 	}
 	doneSignalChan <- struct{}{}
 	close(accurateChan)
+	if v != nil {
+		v.close()
+	}
 	wg.Wait()
 
-	log.Printf("Found %v large directories in %q.", offenderTotal, rootPath)
+	// Save final walk state, now with accurate counts
+	if state != nil {
+		savedOffenders = savedOffenders[:0]
+		if resumed != nil {
+			savedOffenders = append(savedOffenders, resumed.Offenders...)
+		}
+		for _, o := range offenders {
+			savedOffenders = append(savedOffenders, o.toReport())
+		}
+		saveCheckpoint()
+	}
+	if atomic.LoadInt32(&terminating) != 0 {
+		log.Printf("Walk state saved to %q, exiting program as requested.", *checkpointFile)
+		os.Exit(1)
+	}
+
+	foundTotal := len(offenders)
+	if resumed != nil {
+		foundTotal += len(resumed.Offenders)
+	}
+	if unreadableTotal > 0 {
+		log.Printf("Found %v large directories in %q, %v of them unreadable.", foundTotal, rootPath,
+			unreadableTotal)
+	} else {
+		log.Printf("Found %v large directories in %q.", foundTotal, rootPath)
+	}
+	walkErrs.report(rootPath)
+	limits.report(rootPath, nlinkUsable)
+	throttled := metadataThrottle.throttledTime() - throttledStart
+	if throttled > 0 {
+		log.Printf("Scan of %q was throttled for %v due to system pressure.", rootPath, roundDuration(throttled))
+	}
+
+	report := newRootReport(rootPath, offenders, walkErrs)
+	report.Throttled = throttled.Seconds()
+	if resumed != nil {
+		report.Offenders = append(append([]offenderReport(nil), resumed.Offenders...), report.Offenders...)
+	}
+	report.Incomplete = limits.reason
+	report.DirsChecked = limits.checked
+	if nlinkUsable {
+		report.DirsFound = limits.found
+	}
+	if v != nil {
+		v.report(rootPath)
+		report.Verification = v.result()
+	}
+
+	return report
+}
+
+// trackVisited checks if a directory is to be checked only once: always when following symlinks, otherwise when its
+// device is mounted more than once. Other directories can't be reached through several paths, so they are not
+// tracked to save memory.
+func trackVisited(di *dirInfo) bool {
+	return *followSymlinksFlag || sharedDevs[di.dev]
 }
 
 // humanPrint will display base10 approximate file count.
@@ -264,4 +730,7 @@ func printPath(processPath *string) {
 	if processPath != nil && *processPath != "" {
 		log.Printf("Last processed path was: %q.", *processPath)
 	}
+	if throttled := metadataThrottle.throttledTime(); throttled > 0 {
+		log.Printf("Scan has been throttled for %v due to system pressure.", roundDuration(throttled))
+	}
 }
@@ -0,0 +1,268 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const markerName = ".findlargedir-marker"
+const staleMarkerAge = time.Hour * 24
+
+// marker identifies the process which has created a calibration directory, and when.
+type marker struct {
+	PID      int       `json:"pid"`
+	Hostname string    `json:"hostname"`
+	Created  time.Time `json:"created"`
+}
+
+// calibrationDir is a calibration directory found in a root directory.
+type calibrationDir struct {
+	path   string
+	marker *marker
+	stale  bool
+	reason string
+}
+
+// makeCalibrationDir will create a temporary calibration directory in checkDir, tagged with a marker so that it can
+// be found and removed if this process dies before removing it. Directory created by a stalled call is removed once
+// the call finishes.
+func makeCalibrationDir(checkDir string) (string, error) {
+	var tempDir string
+	err := guardCall("mkdir", checkDir, func() (err error) {
+		tempDir, err = createCalibrationDir(checkDir)
+		return err
+	}, func() { os.RemoveAll(tempDir) })
+	if err == errStall {
+		return "", &os.PathError{Op: "mkdir", Path: checkDir, Err: err}
+	}
+	return tempDir, err
+}
+
+// createCalibrationDir will create a temporary calibration directory in checkDir along with its marker.
+func createCalibrationDir(checkDir string) (string, error) {
+	tempDir, err := ioutil.TempDir(checkDir, testDirName)
+	if err != nil {
+		return "", err
+	}
+
+	hostname, _ := os.Hostname()
+	content, err := json.Marshal(&marker{PID: os.Getpid(), Hostname: hostname, Created: time.Now()})
+	if err == nil {
+		err = os.WriteFile(filepath.Join(tempDir, markerName), content, 0o600)
+	}
+	if err != nil {
+		os.RemoveAll(tempDir)
+		return "", err
+	}
+
+	return tempDir, nil
+}
+
+// readMarker will read calibration directory marker.
+func readMarker(dir string) (*marker, error) {
+	content, err := os.ReadFile(filepath.Join(dir, markerName))
+	if err != nil {
+		return nil, err
+	}
+
+	m := &marker{}
+	if err := json.Unmarshal(content, m); err != nil {
+		return nil, fmt.Errorf("invalid marker in %q: %w", dir, err)
+	}
+
+	return m, nil
+}
+
+// isStale checks if a marker was left by a process which is no longer running. Processes on other hosts can't be
+// checked, so their markers are stale only when older than staleMarkerAge.
+func (m *marker) isStale(hostname string, now time.Time) (bool, string) {
+	if m.Hostname == hostname {
+		if !processAlive(m.PID) {
+			return true, fmt.Sprintf("process %v is not running", m.PID)
+		}
+		return false, ""
+	}
+	if age := now.Sub(m.Created); age > staleMarkerAge {
+		return true, fmt.Sprintf("created %v ago", age.Round(time.Minute))
+	}
+
+	return false, ""
+}
+
+// isCalibrationDirName checks if name is one of calibration directory names, that is testDirName followed by the
+// random number ioutil.TempDir adds.
+func isCalibrationDirName(name string) bool {
+	suffix := strings.TrimPrefix(name, testDirName)
+	if suffix == name || suffix == "" {
+		return false
+	}
+	for _, c := range suffix {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// findCalibrationDirs will find calibration directories of any process in rootPath. Directories with a missing or
+// unreadable marker are returned with nil marker.
+func findCalibrationDirs(rootPath string) ([]calibrationDir, error) {
+	matches, err := filepath.Glob(filepath.Join(rootPath, testDirName+"*"))
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, _ := os.Hostname()
+	now := time.Now()
+
+	var dirs []calibrationDir
+	for _, path := range matches {
+		if !isCalibrationDirName(filepath.Base(path)) {
+			continue
+		}
+		if fi, err := os.Lstat(path); err != nil || !fi.IsDir() {
+			continue
+		}
+
+		d := calibrationDir{path: path}
+		if m, err := readMarker(path); err == nil {
+			d.marker = m
+			d.stale, d.reason = m.isStale(hostname, now)
+		}
+		dirs = append(dirs, d)
+	}
+
+	return dirs, nil
+}
+
+// describe will display calibration directory origin.
+func (d *calibrationDir) describe() string {
+	if d.marker == nil {
+		return fmt.Sprintf("%q without a valid marker, check and remove it manually", d.path)
+	}
+
+	s := fmt.Sprintf("%q of process %v on %v created at %v", d.path, d.marker.PID, d.marker.Hostname,
+		d.marker.Created.Format(time.RFC3339))
+	if d.stale {
+		return s + ", stale as " + d.reason
+	}
+
+	return s + ", still in use"
+}
+
+// checkCalibrationDirs will look for calibration directories left behind in rootPath by killed processes and report
+// them, offering to remove them only if asked to and running interactively.
+func checkCalibrationDirs(rootPath string) {
+	dirs, err := findCalibrationDirs(rootPath)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	var stale []calibrationDir
+	for i := range dirs {
+		log.Printf("Found calibration directory %v.", dirs[i].describe())
+		if dirs[i].stale {
+			stale = append(stale, dirs[i])
+		}
+	}
+	if len(stale) == 0 {
+		return
+	}
+
+	if !*promptCleanupFlag || !isInteractive() {
+		log.Printf("Run %q to remove stale calibration directories.", os.Args[0]+" cleanup "+rootPath)
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "Remove %v stale calibration directories in %q? [y/N] ", len(stale), rootPath)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if a := strings.ToLower(strings.TrimSpace(answer)); a == "y" || a == "yes" {
+		removeCalibrationDirs(stale)
+	}
+}
+
+// removeCalibrationDirs will remove calibration directories and return the number of failures.
+func removeCalibrationDirs(dirs []calibrationDir) int {
+	var failed int
+	for i := range dirs {
+		log.Printf("Removing stale calibration directory %q, please wait...", dirs[i].path)
+		if err := os.RemoveAll(dirs[i].path); err != nil {
+			log.Print(err)
+			failed++
+		}
+	}
+
+	return failed
+}
+
+// runCleanup will remove stale calibration directories in all roots and return the exit status.
+func runCleanup(roots []string) int {
+	status := 0
+	for _, root := range roots {
+		rootPath := filepath.Clean(root)
+
+		dirs, err := findCalibrationDirs(rootPath)
+		if err != nil {
+			log.Print(err)
+			status = 1
+			continue
+		}
+
+		var stale []calibrationDir
+		for i := range dirs {
+			if dirs[i].stale {
+				stale = append(stale, dirs[i])
+			} else {
+				log.Printf("Skipping calibration directory %v.", dirs[i].describe())
+			}
+		}
+		failed := removeCalibrationDirs(stale)
+		if failed > 0 {
+			status = 1
+		}
+		log.Printf("Removed %v stale calibration directories in %q.", len(stale)-failed, rootPath)
+	}
+
+	return status
+}
+
+// isInteractive checks if standard input is a terminal, that is a character device other than null device.
+func isInteractive() bool {
+	fi, err := os.Stdin.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+
+	null, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(fi, null)
+}
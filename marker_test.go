@@ -0,0 +1,94 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMarkerIsStale(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		name   string
+		marker marker
+		stale  bool
+	}{
+		{"this process", marker{PID: os.Getpid(), Hostname: "here", Created: now}, false},
+		{"dead process", marker{PID: -1, Hostname: "here", Created: now}, true},
+		{"long running process", marker{PID: os.Getpid(), Hostname: "here", Created: now.Add(-2 * staleMarkerAge)}, false},
+		{"other host", marker{PID: -1, Hostname: "there", Created: now}, false},
+		{"other host too old", marker{PID: -1, Hostname: "there", Created: now.Add(-2 * staleMarkerAge)}, true},
+	}
+
+	for _, tc := range cases {
+		if stale, reason := tc.marker.isStale("here", now); stale != tc.stale {
+			t.Errorf("%v: isStale() = %v (%q); want %v", tc.name, stale, reason, tc.stale)
+		}
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	root := t.TempDir()
+
+	active, err := makeCalibrationDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale, err := makeCalibrationDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := []byte(`{"pid":1,"hostname":"elsewhere","created":"2006-01-02T15:04:05Z"}`)
+	if err := os.WriteFile(filepath.Join(stale, markerName), old, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	unmarked := filepath.Join(root, testDirName+"1")
+	if err := os.Mkdir(unmarked, 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	unrelated := filepath.Join(root, testDirName+"-go")
+	if err := os.Mkdir(unrelated, 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	dirs, err := findCalibrationDirs(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) != 3 {
+		t.Errorf("findCalibrationDirs() found %v directories; want 3", len(dirs))
+	}
+
+	if status := runCleanup([]string{root}); status != 0 {
+		t.Errorf("runCleanup() = %v; want 0", status)
+	}
+
+	for path, want := range map[string]bool{active: true, stale: false, unmarked: true, unrelated: true} {
+		if _, err := os.Stat(path); (err == nil) != want {
+			t.Errorf("%q exists = %v; want %v", path, err == nil, want)
+		}
+	}
+}
@@ -0,0 +1,7 @@
+//go:build 386 || amd64 || arm64
+// +build 386 amd64 arm64
+
+package monkey
+
+// archSupported reports whether jmpToFunctionValue is implemented for GOARCH
+const archSupported = true
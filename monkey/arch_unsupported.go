@@ -0,0 +1,17 @@
+//go:build !386 && !amd64 && !arm64
+// +build !386,!amd64,!arm64
+
+package monkey
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// archSupported reports whether jmpToFunctionValue is implemented for GOARCH
+const archSupported = false
+
+// Panics, there is no jump stub for this architecture
+func jmpToFunctionValue(to uintptr) []byte {
+	panic(fmt.Sprintf("monkey: unsupported architecture %s", runtime.GOARCH))
+}
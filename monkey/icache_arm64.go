@@ -0,0 +1,7 @@
+package monkey
+
+// flushInstructionCache makes instructions written to [addr, addr+size) visible
+// to instruction fetch of all cores: it cleans data cache to the point of
+// unification and invalidates instruction cache for the range. Implemented in
+// icache_arm64.s
+func flushInstructionCache(addr, size uintptr)
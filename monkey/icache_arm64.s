@@ -0,0 +1,31 @@
+#include "textflag.h"
+
+// func flushInstructionCache(addr, size uintptr)
+// Cache maintenance is done every 4 bytes instead of every cache line, which
+// avoids reading CTR_EL0 and is cheap for the few bytes of a jump stub
+TEXT ·flushInstructionCache(SB), NOSPLIT, $0-16
+	MOVD	addr+0(FP), R0
+	MOVD	size+8(FP), R1
+	ADD	R0, R1, R1
+
+	MOVD	R0, R2
+clean:
+	CMP	R1, R2
+	BHS	cleaned
+	DC	CVAU, R2
+	ADD	$4, R2
+	B	clean
+cleaned:
+	DSB	$11 // ish
+
+	MOVD	R0, R2
+invalidate:
+	CMP	R1, R2
+	BHS	invalidated
+	WORD	$0xd50b7522 // ic ivau, x2
+	ADD	$4, R2
+	B	invalidate
+invalidated:
+	DSB	$11 // ish
+	ISB	$15 // sy
+	RET
@@ -0,0 +1,8 @@
+//go:build !arm64
+// +build !arm64
+
+package monkey
+
+// flushInstructionCache does nothing, as x86 keeps instruction fetch coherent
+// with data writes
+func flushInstructionCache(addr, size uintptr) {}
@@ -1,8 +1,10 @@
 package monkey
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
+	"runtime"
 	"sync"
 	"unsafe"
 )
@@ -20,6 +22,35 @@ var (
 	patches = make(map[reflect.Value]patch)
 )
 
+// ErrUnsupportedArch is returned by Supported when there is no jump stub
+// implementation for the running architecture
+var ErrUnsupportedArch = errors.New("monkey: unsupported architecture " + runtime.GOARCH)
+
+// Supported returns ErrUnsupportedArch if patching cannot work on this
+// architecture at all
+func Supported() error {
+	if !archSupported {
+		return ErrUnsupportedArch
+	}
+	return nil
+}
+
+// CanPatch checks if target can be patched without actually patching it:
+// the architecture has to be supported and target has to be a function
+// large enough to hold the jump stub
+func CanPatch(target interface{}) error {
+	if err := Supported(); err != nil {
+		return err
+	}
+
+	t := reflect.ValueOf(target)
+	if t.Kind() != reflect.Func {
+		return errors.New("monkey: target has to be a Func")
+	}
+
+	return checkFunctionSize(*(*uintptr)(getPtr(t)), len(jmpToFunctionValue(0)))
+}
+
 type value struct {
 	_   uintptr
 	ptr unsafe.Pointer
@@ -54,20 +85,35 @@ func Patch(target, replacement interface{}) *PatchGuard {
 // PatchInstanceMethod replaces an instance method methodName for the type target with replacement
 // Replacement should expect the receiver (of type target) as the first argument
 func PatchInstanceMethod(target reflect.Type, methodName string, replacement interface{}) *PatchGuard {
+	m := methodValue(target, methodName)
+	r := reflect.ValueOf(replacement)
+	patchValue(m, r)
+
+	return &PatchGuard{m, r}
+}
+
+// methodValue returns the function value of methodName for the type target
+func methodValue(target reflect.Type, methodName string) reflect.Value {
 	m, ok := target.MethodByName(methodName)
 	if !ok {
 		panic(fmt.Sprintf("unknown method %s", methodName))
 	}
-	r := reflect.ValueOf(replacement)
-	patchValue(m.Func, r)
-
-	return &PatchGuard{m.Func, r}
+	return m.Func
 }
 
 func patchValue(target, replacement reflect.Value) {
 	lock.Lock()
 	defer lock.Unlock()
 
+	patchValueLocked(target, replacement)
+}
+
+// patchValueLocked is patchValue for callers already holding lock
+func patchValueLocked(target, replacement reflect.Value) {
+	if err := Supported(); err != nil {
+		panic(err)
+	}
+
 	if target.Kind() != reflect.Func {
 		panic("target has to be a Func")
 	}
@@ -97,11 +143,7 @@ func Unpatch(target interface{}) bool {
 // UnpatchInstanceMethod removes the patch on methodName of the target
 // returns whether it was patched in the first place
 func UnpatchInstanceMethod(target reflect.Type, methodName string) bool {
-	m, ok := target.MethodByName(methodName)
-	if !ok {
-		panic(fmt.Sprintf("unknown method %s", methodName))
-	}
-	return unpatchValue(m.Func)
+	return unpatchValue(methodValue(target, methodName))
 }
 
 // UnpatchAll removes all applied monkeypatches
@@ -119,6 +161,12 @@ func UnpatchAll() {
 func unpatchValue(target reflect.Value) bool {
 	lock.Lock()
 	defer lock.Unlock()
+
+	return unpatchValueLocked(target)
+}
+
+// unpatchValueLocked is unpatchValue for callers already holding lock
+func unpatchValueLocked(target reflect.Value) bool {
 	patch, ok := patches[target]
 	if !ok {
 		return false
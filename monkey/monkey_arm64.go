@@ -0,0 +1,30 @@
+package monkey
+
+import "encoding/binary"
+
+// Assembles a jump to a function value
+func jmpToFunctionValue(to uintptr) []byte {
+	res := make([]byte, 0, 24)
+	res = append(res, movImm(0x2, 0, to)...)     // movz x26, to[0:16]
+	res = append(res, movImm(0x3, 1, to>>16)...) // movk x26, to[16:32], lsl #16
+	res = append(res, movImm(0x3, 2, to>>32)...) // movk x26, to[32:48], lsl #32
+	res = append(res, movImm(0x3, 3, to>>48)...) // movk x26, to[48:64], lsl #48
+	res = append(res, 0x4A, 0x03, 0x40, 0xF9)    // ldr x10, [x26]
+	res = append(res, 0x40, 0x01, 0x1F, 0xD6)    // br x10
+	return res
+}
+
+// movImm encodes a 64-bit MOVZ (opc 0b10) or MOVK (opc 0b11) of a 16-bit
+// immediate into x26, the closure context register in the Go arm64 ABI
+func movImm(opc, hw uint32, val uintptr) []byte {
+	m := uint32(26)              // Rd
+	m |= uint32(val&0xFFFF) << 5 // imm16
+	m |= (hw & 0x3) << 21        // hw
+	m |= 0x25 << 23              // move wide immediate
+	m |= (opc & 0x3) << 29       // opc
+	m |= 0x1 << 31               // sf: 64-bit variant
+
+	res := make([]byte, 4)
+	binary.LittleEndian.PutUint32(res, m)
+	return res
+}
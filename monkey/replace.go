@@ -1,27 +1,46 @@
 package monkey
 
 import (
-	"golang.org/x/sys/unix"
-	"reflect"
+	"fmt"
+	"os"
+	"runtime"
 	"unsafe"
 )
 
 func rawMemoryAccess(p uintptr, length int) []byte {
-	return *(*[]byte)(unsafe.Pointer(&reflect.SliceHeader{
-		Data: p,
-		Len:  length,
-		Cap:  length,
-	}))
+	// go vet rightfully frowns upon uintptr to unsafe.Pointer conversion,
+	// however p is a code address and is never moved by the GC
+	return unsafe.Slice(*(**byte)(unsafe.Pointer(&p)), length)
 }
 
 func pageStart(ptr uintptr) uintptr {
-	return ptr & ^(uintptr(unix.Getpagesize() - 1))
+	return ptr & ^(uintptr(os.Getpagesize() - 1))
+}
+
+// checkFunctionSize verifies that the jump stub written at from stays within
+// the body of the function starting at from
+func checkFunctionSize(from uintptr, size int) error {
+	f := runtime.FuncForPC(from)
+	if f == nil || f.Entry() != from {
+		return fmt.Errorf("monkey: no function starts at %#x", from)
+	}
+
+	end := runtime.FuncForPC(from + uintptr(size) - 1)
+	if end == nil || end.Entry() != from {
+		return fmt.Errorf("monkey: function %s is too small to hold a %d byte jump", f.Name(), size)
+	}
+
+	return nil
 }
 
 // from is a pointer to the actual function
 // to is a pointer to a go funcvalue
 func replaceFunction(from, to uintptr) (original []byte) {
 	jumpData := jmpToFunctionValue(to)
+	if err := checkFunctionSize(from, len(jumpData)); err != nil {
+		panic(err)
+	}
+
 	f := rawMemoryAccess(from, len(jumpData))
 	original = make([]byte, len(f))
 	copy(original, f)
@@ -1,8 +1,11 @@
-//+build !windows
+//go:build !windows
+// +build !windows
 
 package monkey
 
 import (
+	"os"
+
 	"golang.org/x/sys/unix"
 )
 
@@ -12,12 +15,13 @@ import (
 func copyToLocation(location uintptr, data []byte) {
 	f := rawMemoryAccess(location, len(data))
 
-	page := rawMemoryAccess(pageStart(location), unix.Getpagesize())
+	page := rawMemoryAccess(pageStart(location), os.Getpagesize())
 	err := unix.Mprotect(page, unix.PROT_READ|unix.PROT_WRITE|unix.PROT_EXEC)
 	if err != nil {
 		panic(err)
 	}
 	copy(f, data[:])
+	flushInstructionCache(location, uintptr(len(data)))
 
 	err = unix.Mprotect(page, unix.PROT_READ|unix.PROT_EXEC)
 	if err != nil {
@@ -7,7 +7,10 @@ import (
 
 const PAGE_EXECUTE_READWRITE = 0x40
 
-var procVirtualProtect = syscall.NewLazyDLL("kernel32.dll").NewProc("VirtualProtect")
+var kernel32 = syscall.NewLazyDLL("kernel32.dll")
+var procVirtualProtect = kernel32.NewProc("VirtualProtect")
+var procGetCurrentProcess = kernel32.NewProc("GetCurrentProcess")
+var procFlushInstructionCache = kernel32.NewProc("FlushInstructionCache")
 
 func virtualProtect(lpAddress uintptr, dwSize int, flNewProtect uint32, lpflOldProtect unsafe.Pointer) error {
 	ret, _, _ := procVirtualProtect.Call(
@@ -34,6 +37,11 @@ func copyToLocation(location uintptr, data []byte) {
 	}
 	copy(f, data[:])
 
+	// Make the new instructions visible to instruction fetch on architectures
+	// which don't keep it coherent with data writes, like arm64
+	process, _, _ := procGetCurrentProcess.Call()
+	procFlushInstructionCache.Call(process, location, uintptr(len(data)))
+
 	// VirtualProtect requires you to pass in a pointer which it can write the
 	// current memory protection permissions to, even if you don't want them.
 	var tmp uint32
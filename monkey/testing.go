@@ -0,0 +1,119 @@
+package monkey
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// TB is the subset of testing.TB used by PatchT, declared here so that the
+// package does not have to import testing
+type TB interface {
+	Helper()
+	Name() string
+	Cleanup(func())
+}
+
+// testOwner serialises tests which patch: only one test (and its subtests)
+// may hold patches at any time
+type testOwner struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	name  string
+	depth int
+}
+
+var owner = func() *testOwner {
+	o := &testOwner{}
+	o.cond = sync.NewCond(&o.mu)
+	return o
+}()
+
+// acquire blocks until no other test holds patches. A test which already
+// holds patches, or one of its subtests, acquires again without blocking
+func (o *testOwner) acquire(name string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for o.depth > 0 && o.name != name && !strings.HasPrefix(name, o.name+"/") {
+		o.cond.Wait()
+	}
+	if o.depth == 0 {
+		o.name = name
+	}
+	o.depth++
+}
+
+func (o *testOwner) release() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.depth--
+	if o.depth == 0 {
+		o.name = ""
+		o.cond.Broadcast()
+	}
+}
+
+// PatchT replaces a function with another for the lifetime of test t. The patch
+// is removed (and any patch it replaced put back) by t.Cleanup. Tests using
+// PatchT are serialised, a parallel test calling PatchT will block until all
+// patches of the running test have been removed
+func PatchT(t TB, target, replacement interface{}) *PatchGuard {
+	t.Helper()
+	return patchT(t, reflect.ValueOf(target), reflect.ValueOf(replacement))
+}
+
+// PatchInstanceMethodT is PatchInstanceMethod for the lifetime of test t, see PatchT
+func PatchInstanceMethodT(t TB, target reflect.Type, methodName string, replacement interface{}) *PatchGuard {
+	t.Helper()
+	return patchT(t, methodValue(target, methodName), reflect.ValueOf(replacement))
+}
+
+func patchT(t TB, target, replacement reflect.Value) *PatchGuard {
+	owner.acquire(t.Name())
+	restore := patchTemporarily(target, replacement)
+	t.Cleanup(func() {
+		restore()
+		owner.release()
+	})
+
+	return &PatchGuard{target, replacement}
+}
+
+// WithPatch replaces a function with another only while running f. Once f
+// returns or panics the patch is removed and any patch it replaced put back.
+// Like PatchT it is serialised against patches of other tests
+func WithPatch(t TB, target, replacement interface{}, f func()) {
+	t.Helper()
+	owner.acquire(t.Name())
+	defer owner.release()
+
+	restore := patchTemporarily(reflect.ValueOf(target), reflect.ValueOf(replacement))
+	defer restore()
+
+	f()
+}
+
+// patchTemporarily patches target and returns a function undoing just that,
+// that is restoring the previous patch of target if there was one. Looking up
+// the previous patch and replacing it happen under a single hold of lock, as
+// does the restore
+func patchTemporarily(target, replacement reflect.Value) func() {
+	lock.Lock()
+	defer lock.Unlock()
+
+	previous, patched := patches[target]
+	patchValueLocked(target, replacement)
+
+	return func() {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if patched {
+			patchValueLocked(target, *previous.replacement)
+			return
+		}
+		unpatchValueLocked(target)
+	}
+}
@@ -0,0 +1,74 @@
+package monkey_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dkorunic/findlargedir/monkey"
+)
+
+//go:noinline
+func greet(name string) string {
+	return fmt.Sprintf("hello %s", name)
+}
+
+func skipUnsupported(t *testing.T) {
+	if err := monkey.CanPatch(greet); err != nil {
+		t.Skip(err)
+	}
+}
+
+func TestPatchT(t *testing.T) {
+	skipUnsupported(t)
+
+	t.Run("patched", func(t *testing.T) {
+		monkey.PatchT(t, greet, func(name string) string { return "bye " + name })
+		if got := greet("world"); got != "bye world" {
+			t.Errorf("greet() = %q; want %q", got, "bye world")
+		}
+
+		t.Run("nested", func(t *testing.T) {
+			monkey.PatchT(t, greet, func(name string) string { return "ciao " + name })
+			if got := greet("world"); got != "ciao world" {
+				t.Errorf("greet() = %q; want %q", got, "ciao world")
+			}
+		})
+
+		if got := greet("world"); got != "bye world" {
+			t.Errorf("after nested cleanup greet() = %q; want %q", got, "bye world")
+		}
+	})
+
+	if got := greet("world"); got != "hello world" {
+		t.Errorf("after cleanup greet() = %q; want %q", got, "hello world")
+	}
+}
+
+func TestWithPatch(t *testing.T) {
+	skipUnsupported(t)
+
+	monkey.WithPatch(t, greet, func(name string) string { return "bye " + name }, func() {
+		if got := greet("world"); got != "bye world" {
+			t.Errorf("greet() = %q; want %q", got, "bye world")
+		}
+	})
+
+	if got := greet("world"); got != "hello world" {
+		t.Errorf("after WithPatch greet() = %q; want %q", got, "hello world")
+	}
+}
+
+func TestWithPatchNested(t *testing.T) {
+	skipUnsupported(t)
+
+	monkey.PatchT(t, greet, func(name string) string { return "bye " + name })
+	monkey.WithPatch(t, greet, func(name string) string { return "ciao " + name }, func() {
+		if got := greet("world"); got != "ciao world" {
+			t.Errorf("greet() = %q; want %q", got, "ciao world")
+		}
+	})
+
+	if got := greet("world"); got != "bye world" {
+		t.Errorf("after WithPatch greet() = %q; want %q", got, "bye world")
+	}
+}
@@ -0,0 +1,285 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// mountInfoPath is the mount table of the current process.
+const mountInfoPath = "/proc/self/mountinfo"
+
+// mountInfo is a single mount from mountinfo.
+type mountInfo struct {
+	id         uint64
+	parent     uint64
+	dev        uint64
+	root       string
+	mountPoint string
+	fsType     string
+	source     string
+	superOpts  string
+}
+
+// mountTable holds all mounts in mountinfo order, in which mounts come after the mounts they are mounted on.
+type mountTable struct {
+	mounts []mountInfo
+	byID   map[uint64]int
+}
+
+// loadMounts reads the mount table of the current process.
+func loadMounts() (*mountTable, error) {
+	f, err := os.Open(mountInfoPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseMountInfo(f)
+}
+
+// parseMountInfo parses mount table in mountinfo format, see proc(5).
+func parseMountInfo(r io.Reader) (*mountTable, error) {
+	t := &mountTable{byID: map[uint64]int{}}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+
+		// Optional fields end with a separator, followed by filesystem type, source and superblock options
+		sep := 6
+		for sep < len(fields) && fields[sep] != "-" {
+			sep++
+		}
+		if sep+2 >= len(fields) {
+			return nil, fmt.Errorf("invalid mountinfo line: %q", scanner.Text())
+		}
+
+		var m mountInfo
+		var major, minor uint32
+		var err error
+		if m.id, err = strconv.ParseUint(fields[0], 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid mount ID in mountinfo line: %q", scanner.Text())
+		}
+		if m.parent, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid parent mount ID in mountinfo line: %q", scanner.Text())
+		}
+		if _, err := fmt.Sscanf(fields[2], "%d:%d", &major, &minor); err != nil {
+			return nil, fmt.Errorf("invalid device in mountinfo line: %q", scanner.Text())
+		}
+		m.dev = unix.Mkdev(major, minor)
+		m.root, m.mountPoint = unescapeMountPath(fields[3]), unescapeMountPath(fields[4])
+		m.fsType, m.source = fields[sep+1], unescapeMountPath(fields[sep+2])
+		if sep+3 < len(fields) {
+			m.superOpts = fields[sep+3]
+		}
+
+		t.byID[m.id] = len(t.mounts)
+		t.mounts = append(t.mounts, m)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// unescapeMountPath decodes octal escapes of space, tab, newline and backslash in mountinfo paths.
+func unescapeMountPath(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// sharedDevices returns devices mounted more than once, such as with bind mounts, where the same directory can be
+// reached through several paths.
+func (t *mountTable) sharedDevices() map[uint64]bool {
+	count := map[uint64]int{}
+	for _, m := range t.mounts {
+		count[m.dev]++
+	}
+
+	shared := map[uint64]bool{}
+	for dev, n := range count {
+		if n > 1 {
+			shared[dev] = true
+		}
+	}
+	return shared
+}
+
+// mountOf returns the mount a directory is on: by mount ID when known (Linux >= 5.8), otherwise the last mount on
+// the same device with the longest mount point containing the pathname. Btrfs subvolumes have their own devices,
+// so for them it falls back to any mount containing the pathname.
+func (t *mountTable) mountOf(osPathname string, di *dirInfo) *mountInfo {
+	if di.hasMntID {
+		if i, ok := t.byID[di.mntID]; ok {
+			return &t.mounts[i]
+		}
+	}
+
+	if m := t.mountOfPath(osPathname, di.dev, true); m != nil {
+		return m
+	}
+	return t.mountOfPath(osPathname, 0, false)
+}
+
+// mountOfPath returns the last mount with the longest mount point containing the pathname, optionally only on given
+// device.
+func (t *mountTable) mountOfPath(osPathname string, dev uint64, sameDev bool) *mountInfo {
+	var found *mountInfo
+	for i := range t.mounts {
+		m := &t.mounts[i]
+		if (sameDev && m.dev != dev) || !isSubpath(m.mountPoint, osPathname) {
+			continue
+		}
+		if found == nil || len(m.mountPoint) >= len(found.mountPoint) {
+			found = m
+		}
+	}
+	return found
+}
+
+// canonicalPath returns pathname of a directory through the canonical mount of its filesystem, that is the first
+// mount exposing the most of it, or empty string if it is the same or unknown. For instance, directory found through
+// a bind mount of a subdirectory is attributed to the mount of the whole filesystem.
+func (t *mountTable) canonicalPath(osPathname string, di *dirInfo) string {
+	absPathname, err := filepath.Abs(osPathname)
+	if err != nil {
+		return ""
+	}
+
+	m := t.mountOf(absPathname, di)
+	if m == nil {
+		return ""
+	}
+
+	// Pathname within the filesystem
+	rel, err := filepath.Rel(m.mountPoint, absPathname)
+	if err != nil || isOutside(rel) {
+		return ""
+	}
+	fsPathname := filepath.Join(m.root, rel)
+
+	var canonical *mountInfo
+	for i := range t.mounts {
+		c := &t.mounts[i]
+		if c.dev == m.dev && isSubpath(c.root, fsPathname) && (canonical == nil || len(c.root) < len(canonical.root)) {
+			canonical = c
+		}
+	}
+	if canonical == nil {
+		return ""
+	}
+
+	rel, err = filepath.Rel(canonical.root, fsPathname)
+	if err != nil {
+		return ""
+	}
+	if p := filepath.Join(canonical.mountPoint, rel); p != absPathname {
+		return p
+	}
+	return ""
+}
+
+// mountBoundaryKey returns key identifying the mount, filesystem or pool of a directory from the mount table. Mounts
+// of the same superblock, like btrfs subvolumes and bind mounts, share device number in mountinfo and therefore the
+// filesystem.
+func mountBoundaryKey(mode, osPathname string, di *dirInfo) (string, bool) {
+	if mounts == nil {
+		return "", false
+	}
+
+	m := mounts.mountOf(osPathname, di)
+	if m == nil {
+		return "", false
+	}
+
+	switch mode {
+	case boundaryMount:
+		return fmt.Sprintf("mount:%v", m.id), true
+	case boundaryPool:
+		return mounts.poolID(m), true
+	}
+	return m.filesystemID(), true
+}
+
+// filesystemID returns identity of the filesystem of a mount.
+func (m *mountInfo) filesystemID() string {
+	return fmt.Sprintf("dev:%v:%v", unix.Major(m.dev), unix.Minor(m.dev))
+}
+
+// poolID returns identity of the storage pool of a mount: ZFS pool of ZFS datasets, LVM volume group of logical
+// volumes and pool of the upper directory of overlay mounts. Other filesystems are pools of their own.
+func (t *mountTable) poolID(m *mountInfo) string {
+	switch m.fsType {
+	case "zfs":
+		return "zfs:" + strings.SplitN(m.source, "/", 2)[0]
+	case "overlay":
+		for _, opt := range strings.Split(m.superOpts, ",") {
+			if upperDir := strings.TrimPrefix(opt, "upperdir="); upperDir != opt {
+				if upper := t.mountOfPath(upperDir, 0, false); upper != nil && upper.fsType != "overlay" {
+					return t.poolID(upper)
+				}
+			}
+		}
+	}
+
+	// Btrfs and other filesystems on top of device mapper report their own device, so look at the source device
+	dev := m.dev
+	var st unix.Stat_t
+	if unix.Stat(m.source, &st) == nil && st.Mode&unix.S_IFMT == unix.S_IFBLK {
+		dev = uint64(st.Rdev)
+	}
+	if uuid, err := os.ReadFile(fmt.Sprintf("/sys/dev/block/%v:%v/dm/uuid", unix.Major(dev), unix.Minor(dev))); err == nil {
+		// Logical volume UUID is "LVM-" followed by volume group and logical volume UUIDs, 32 characters each
+		if s := strings.TrimSpace(string(uuid)); strings.HasPrefix(s, "LVM-") && len(s) >= 36 {
+			return "lvm:" + s[4:36]
+		}
+	}
+
+	return m.filesystemID()
+}
@@ -0,0 +1,48 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+//go:build !linux
+// +build !linux
+
+package main
+
+// mountTable is a dummy mount table, as mountinfo is Linux specific.
+type mountTable struct{}
+
+// loadMounts returns no mount table on this platform.
+func loadMounts() (*mountTable, error) {
+	return nil, nil
+}
+
+// sharedDevices returns no devices on this platform.
+func (t *mountTable) sharedDevices() map[uint64]bool {
+	return nil
+}
+
+// canonicalPath returns no canonical pathname on this platform.
+func (t *mountTable) canonicalPath(osPathname string, di *dirInfo) string {
+	return ""
+}
+
+// mountBoundaryKey returns no key on this platform.
+func mountBoundaryKey(mode, osPathname string, di *dirInfo) (string, bool) {
+	return "", false
+}
@@ -0,0 +1,178 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+const testMountInfo = `22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+23 22 0:21 / /proc rw,nosuid - proc proc rw
+24 22 8:2 / /srv/data rw,relatime shared:2 master:1 - xfs /dev/sda2 rw
+25 22 8:2 /app\040logs /mnt/app\040logs rw,relatime - xfs /dev/sda2 rw
+`
+
+func TestParseMountInfo(t *testing.T) {
+	mounts, err := parseMountInfo(strings.NewReader(testMountInfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mounts.mounts) != 4 {
+		t.Fatalf("got %v mounts, want 4", len(mounts.mounts))
+	}
+	m := mounts.mounts[3]
+	if m.id != 25 || m.parent != 22 || m.dev != unix.Mkdev(8, 2) || m.root != "/app logs" ||
+		m.mountPoint != "/mnt/app logs" || m.fsType != "xfs" || m.source != "/dev/sda2" {
+		t.Errorf("unexpected mount: %+v", m)
+	}
+
+	shared := mounts.sharedDevices()
+	if len(shared) != 1 || !shared[unix.Mkdev(8, 2)] {
+		t.Errorf("got shared devices %v, want only 8:2", shared)
+	}
+
+	if _, err := parseMountInfo(strings.NewReader("22 1 8:1 / /\n")); err == nil {
+		t.Error("expected error parsing truncated line")
+	}
+}
+
+func TestCanonicalPath(t *testing.T) {
+	// Device exposed only through a bind mount of a subdirectory
+	mounts, err := parseMountInfo(strings.NewReader(testMountInfo + "26 22 8:3 /sub /mnt/sub rw - xfs /dev/sda3 rw\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		pathname string
+		di       dirInfo
+		want     string
+	}{
+		// Bind mount of a subdirectory, found by mount ID and by device
+		{"/mnt/app logs/x", dirInfo{dev: unix.Mkdev(8, 2), mntID: 25, hasMntID: true}, "/srv/data/app logs/x"},
+		{"/mnt/app logs/x", dirInfo{dev: unix.Mkdev(8, 2)}, "/srv/data/app logs/x"},
+		// Already canonical
+		{"/srv/data/app logs", dirInfo{dev: unix.Mkdev(8, 2), mntID: 24, hasMntID: true}, ""},
+		{"/usr", dirInfo{dev: unix.Mkdev(8, 1)}, ""},
+		// Unknown device
+		{"/mnt/other", dirInfo{dev: unix.Mkdev(9, 1)}, ""},
+		// Reached through a symlinked prefix, outside of its mount
+		{"/home/logs/x", dirInfo{dev: unix.Mkdev(8, 2), mntID: 25, hasMntID: true}, ""},
+		{"/opt/x", dirInfo{dev: unix.Mkdev(8, 3), mntID: 26, hasMntID: true}, ""},
+		{"/mnt/sub/x", dirInfo{dev: unix.Mkdev(8, 3), mntID: 26, hasMntID: true}, ""},
+	}
+
+	for _, tt := range tests {
+		if got := mounts.canonicalPath(tt.pathname, &tt.di); got != tt.want {
+			t.Errorf("canonicalPath(%q) = %q, want %q", tt.pathname, got, tt.want)
+		}
+	}
+}
+
+func TestMountBoundaryKey(t *testing.T) {
+	table, err := parseMountInfo(strings.NewReader(testMountInfo + `30 22 0:50 / /tank rw - zfs tank rw
+31 30 0:51 / /tank/child rw - zfs tank/child rw
+32 22 0:52 / /merged rw - overlay overlay rw,lowerdir=/l,upperdir=/tank/child/upper,workdir=/tank/child/work
+33 22 0:60 /@ /data rw - btrfs /dev/missing rw,subvol=/@
+34 33 0:60 /@home /data/home rw - btrfs /dev/missing rw,subvol=/@home
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := mounts
+	mounts = table
+	defer func() { mounts = saved }()
+
+	key := func(mode, pathname string, di dirInfo) string {
+		k, ok := mountBoundaryKey(mode, pathname, &di)
+		if !ok {
+			t.Fatalf("no %v key for %q", mode, pathname)
+		}
+		return k
+	}
+
+	// Btrfs subvolumes, mounted or not, are a single filesystem but not a single mount
+	data, home := dirInfo{dev: unix.Mkdev(0, 60)}, dirInfo{dev: unix.Mkdev(0, 60)}
+	snapshot := dirInfo{dev: unix.Mkdev(0, 99)}
+	if key(boundaryFilesystem, "/data", data) != key(boundaryFilesystem, "/data/home", home) ||
+		key(boundaryFilesystem, "/data", data) != key(boundaryFilesystem, "/data/snapshot", snapshot) {
+		t.Error("btrfs subvolumes are on different filesystems")
+	}
+	if key(boundaryMount, "/data", data) == key(boundaryMount, "/data/home", home) {
+		t.Error("btrfs subvolume mounts are the same mount")
+	}
+	if key(boundaryMount, "/data", data) != key(boundaryMount, "/data/snapshot", snapshot) {
+		t.Error("unmounted btrfs subvolume is on another mount")
+	}
+
+	// ZFS datasets are separate filesystems in a single pool, as is overlay with upper directory in the pool
+	tank, child, merged := dirInfo{dev: unix.Mkdev(0, 50)}, dirInfo{dev: unix.Mkdev(0, 51)}, dirInfo{dev: unix.Mkdev(0, 52)}
+	if key(boundaryFilesystem, "/tank", tank) == key(boundaryFilesystem, "/tank/child", child) {
+		t.Error("ZFS datasets are on the same filesystem")
+	}
+	for _, k := range []string{key(boundaryPool, "/tank", tank), key(boundaryPool, "/tank/child", child),
+		key(boundaryPool, "/merged", merged)} {
+		if k != "zfs:tank" {
+			t.Errorf("got pool %q, want zfs:tank", k)
+		}
+	}
+}
+
+func TestFilesystemBoundarySource(t *testing.T) {
+	table, err := parseMountInfo(strings.NewReader(`33 22 0:60 /@ /data rw - btrfs /dev/missing rw,subvol=/@
+34 33 0:60 /@home /data/home rw - btrfs /dev/missing rw,subvol=/@home
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := mounts
+	mounts = table
+	defer func() { mounts = saved }()
+
+	b := newFilesystemBoundary(boundaryFilesystem, "/data", &dirInfo{dev: unix.Mkdev(0, 60)})
+	if b.source != keyMountTable {
+		t.Fatalf("root key source is %v; want mount table", b.source)
+	}
+
+	// Directories are keyed from the mount table as well, even when it has no key for them
+	tests := []struct {
+		pathname string
+		di       dirInfo
+		want     bool
+	}{
+		{"/data/snapshot", dirInfo{dev: unix.Mkdev(0, 99)}, false},
+		{"/data/home", dirInfo{dev: unix.Mkdev(0, 60)}, false},
+		{"/srv", dirInfo{dev: unix.Mkdev(8, 1)}, true},
+	}
+	for _, tc := range tests {
+		if got := b.crosses(tc.pathname, &tc.di); got != tc.want {
+			t.Errorf("crosses(%q) = %v; want %v", tc.pathname, got, tc.want)
+		}
+	}
+}
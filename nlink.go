@@ -0,0 +1,82 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+)
+
+// checkNlink will verify if st_nlink of a directory on the given filesystem counts its subdirectories, that is if
+// an empty directory has st_nlink of 2 which grows by one for every subdirectory. It does not on btrfs (always 1)
+// and on Windows for instance.
+func checkNlink(checkDir string) bool {
+	tempDir, err := makeCalibrationDir(checkDir)
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+	defer removeAllThrottled(tempDir)
+
+	before, err := statPath(tempDir, false)
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+
+	if err := mkdirGuarded(filepath.Join(tempDir, testDirName)); err != nil {
+		log.Print(err)
+		return false
+	}
+
+	after, err := statPath(tempDir, false)
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+
+	return before.nlink == 2 && after.nlink == 3
+}
+
+// estimateSubdirs will return estimated number of subdirectories from st_nlink. It is not known when st_nlink is
+// meaningless on a filesystem, or when it has overflowed and has been set to 1 as on ext4 with dir_nlink feature.
+func estimateSubdirs(di *dirInfo, nlinkUsable bool) (int64, bool) {
+	if !nlinkUsable || di.nlink < 2 {
+		return 0, false
+	}
+	return int64(di.nlink - 2), true
+}
+
+// describeSplit will display the split between files and subdirectories of an estimated entry count. Subdirectory
+// count from st_nlink is exact, so only file count is approximate.
+func describeSplit(entries, subdirs int64, subdirsKnown bool) string {
+	if !subdirsKnown {
+		return "unknown number of subdirectories"
+	}
+
+	files := entries - subdirs
+	if files < 0 {
+		files = 0
+	}
+	return fmt.Sprintf("%v files, %v subdirectories", humanPrint(files), subdirs)
+}
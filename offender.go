@@ -0,0 +1,185 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// offender is a directory found to be possibly too large.
+type offender struct {
+	pathname       string
+	resolved       string
+	canonical      string
+	entries        entryEstimate
+	subdirs        int64
+	subdirsKnown   bool
+	tooManyEntries bool
+	tooManySubdirs bool
+	unreadable     error
+	exact          dirCount
+	exactKnown     bool
+	listingTime    time.Duration
+}
+
+// report will log offender details.
+func (o *offender) report() {
+	var aliases []string
+	if o.resolved != "" {
+		aliases = append(aliases, fmt.Sprintf("resolved to %q", o.resolved))
+	}
+	if o.canonical != "" {
+		aliases = append(aliases, fmt.Sprintf("canonical path %q", o.canonical))
+	}
+	name := fmt.Sprintf("%q", o.pathname)
+	if len(aliases) > 0 {
+		name += " (" + strings.Join(aliases, ", ") + ")"
+	}
+
+	var msg string
+	if o.tooManyEntries {
+		msg = fmt.Sprintf("Directory %v is possibly a large directory with %v entries (between %v and %v, %v)",
+			name, humanPrint(o.entries.best), o.entries.lower, o.entries.upper,
+			describeSplit(o.entries.best, o.subdirs, o.subdirsKnown))
+	} else {
+		msg = fmt.Sprintf("Directory %v has %v subdirectories and possibly %v entries", name, o.subdirs,
+			humanPrint(o.entries.best))
+	}
+
+	if o.unreadable != nil {
+		msg += fmt.Sprintf(", it is unreadable (%v)", unwrapPathError(o.unreadable))
+	} else if roundDuration(o.listingTime) > 0 {
+		msg += fmt.Sprintf(", listing it takes at least %v with a warm cache", roundDuration(o.listingTime))
+	}
+
+	log.Print(msg + ".")
+}
+
+// checkReadable will try to open a directory for reading, as needed to list or count its entries, and to look up a
+// name within it, as needed to enter it.
+func checkReadable(osPathname string) error {
+	metadataThrottle.wait(2)
+	err := guardCall("open", osPathname, func() error {
+		f, err := os.Open(osPathname)
+		if err != nil {
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+
+		_, err = os.Lstat(osPathname + string(os.PathSeparator) + ".")
+		return err
+	}, nil)
+	if err == errStall {
+		return &os.PathError{Op: "open", Path: osPathname, Err: err}
+	}
+	return err
+}
+
+// realPath returns offender pathname with symlinks resolved.
+func (o *offender) realPath() string {
+	if o.resolved != "" {
+		return o.resolved
+	}
+	return o.pathname
+}
+
+// resolvePath returns pathname with all symlinks resolved, or empty string if it contains none or they can't be
+// resolved.
+func resolvePath(osPathname string) string {
+	var resolved string
+	err := guardCall("readlink", osPathname, func() (err error) {
+		resolved, err = filepath.EvalSymlinks(osPathname)
+		return err
+	}, nil)
+	if err != nil || resolved == filepath.Clean(osPathname) {
+		return ""
+	}
+	return resolved
+}
+
+// unwrapPathError returns the underlying error of *os.PathError, as pathname is already reported.
+func unwrapPathError(err error) error {
+	if pe, ok := err.(*os.PathError); ok {
+		return pe.Err
+	}
+	return err
+}
+
+// offenderReport is offender in JSON report.
+type offenderReport struct {
+	Path         string `json:"path"`
+	ResolvedPath string `json:"resolved_path,omitempty"`
+	// CanonicalPath is pathname through the canonical mount of the filesystem when found through another one
+	CanonicalPath string `json:"canonical_path,omitempty"`
+	Entries       int64  `json:"entries"`
+	EntriesLower  int64  `json:"entries_lower"`
+	EntriesUpper  int64  `json:"entries_upper"`
+	Subdirs       *int64 `json:"subdirs,omitempty"`
+	ExactEntries  *int64 `json:"exact_entries,omitempty"`
+	ExactSubdirs  *int64 `json:"exact_subdirs,omitempty"`
+	Unreadable    string `json:"unreadable,omitempty"`
+	// MinListingTime is estimated time in seconds to list the directory with a warm cache, a lower bound
+	MinListingTime float64 `json:"min_listing_time,omitempty"`
+}
+
+// toReport returns offender details for JSON report.
+func (o *offender) toReport() offenderReport {
+	r := offenderReport{
+		Path:          o.pathname,
+		ResolvedPath:  o.resolved,
+		CanonicalPath: o.canonical,
+		Entries:       o.entries.best,
+		EntriesLower:  o.entries.lower,
+		EntriesUpper:  o.entries.upper,
+	}
+	if o.subdirsKnown {
+		r.Subdirs = &o.subdirs
+	}
+	if o.exactKnown {
+		r.ExactEntries = &o.exact.entries
+		r.ExactSubdirs = &o.exact.subdirs
+	}
+	if o.unreadable != nil {
+		r.Unreadable = unwrapPathError(o.unreadable).Error()
+	}
+	r.MinListingTime = o.listingTime.Seconds()
+
+	return r
+}
+
+// roundDuration rounds duration to a precision fitting its magnitude.
+func roundDuration(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Minute:
+		return d.Round(time.Second)
+	case d >= time.Second:
+		return d.Round(time.Millisecond * 100)
+	}
+	return d.Round(time.Millisecond)
+}
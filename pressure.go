@@ -0,0 +1,206 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pressureInterval is how often system pressure is sampled.
+const pressureInterval = time.Second
+
+// slowdownLevel is pressure level, as a fraction of its limit, above which scan is slowed down. At the limit scan is
+// paused until pressure falls.
+const slowdownLevel = 0.5
+
+// slowdownDelay is delay of each metadata operation just below the limit, it grows linearly from slowdownLevel on.
+const slowdownDelay = 10 * time.Millisecond
+
+// pressureSample is system pressure: PSI percentages of time some tasks were stalled on I/O and CPU in the last 10
+// seconds, and 1 minute load average.
+type pressureSample struct {
+	io, cpu, load float64
+	hasIO, hasCPU bool
+}
+
+// pressureLimits are system pressure limits above which scan is paused, zero meaning no limit.
+type pressureLimits struct {
+	io, cpu, load float64
+}
+
+// enabled checks if any limit is set.
+func (l pressureLimits) enabled() bool {
+	return l.io > 0 || l.cpu > 0 || l.load > 0
+}
+
+// check returns an error if a limit is set for pressure which can't be measured.
+func (l pressureLimits) check(s pressureSample) error {
+	if (l.io > 0 && !s.hasIO) || (l.cpu > 0 && !s.hasCPU) {
+		return fmt.Errorf("pressure stall information is not available, Linux >= 4.20 with PSI enabled is needed")
+	}
+	return nil
+}
+
+// level returns the highest ratio of pressure to its limit, and its description.
+func (l pressureLimits) level(s pressureSample) (float64, string) {
+	var level float64
+	var reason string
+	for _, p := range []struct {
+		name         string
+		value, limit float64
+		format       string
+	}{
+		{"I/O pressure", s.io, l.io, "%.2f%%"},
+		{"CPU pressure", s.cpu, l.cpu, "%.2f%%"},
+		{"load average", s.load, l.load, "%.2f"},
+	} {
+		if p.limit <= 0 || p.value/p.limit <= level {
+			continue
+		}
+		level = p.value / p.limit
+		reason = fmt.Sprintf("%v is "+p.format+", limit is "+p.format, p.name, p.value, p.limit)
+	}
+	return level, reason
+}
+
+// pressureMonitor samples system pressure and slows down or pauses metadata operations while it is too high.
+type pressureMonitor struct {
+	limits pressureLimits
+	mu     sync.Mutex
+	resume *sync.Cond
+	level  float64
+	// throttled is total time scan was slowed down or paused, not including the current period since throttledSince
+	throttled      time.Duration
+	throttledSince time.Time
+}
+
+// newPressureMonitor returns monitor for given limits, or nil if there are none. It returns an error if pressure
+// can't be measured.
+func newPressureMonitor(limits pressureLimits) (*pressureMonitor, error) {
+	if !limits.enabled() {
+		return nil, nil
+	}
+
+	s, err := readSystemPressure()
+	if err != nil {
+		return nil, err
+	}
+	if err := limits.check(s); err != nil {
+		return nil, err
+	}
+
+	m := &pressureMonitor{limits: limits}
+	m.resume = sync.NewCond(&m.mu)
+	m.update(s)
+
+	return m, nil
+}
+
+// run will sample system pressure until the program exits.
+func (m *pressureMonitor) run() {
+	ticker := time.NewTicker(pressureInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s, err := readSystemPressure()
+		if err != nil {
+			log.Printf("Unable to read system pressure: %v.", err)
+			continue
+		}
+		m.update(s)
+	}
+}
+
+// update will apply a new pressure sample, pausing or resuming scan as needed.
+func (m *pressureMonitor) update(s pressureSample) {
+	level, reason := m.limits.level(s)
+
+	m.mu.Lock()
+	paused := m.level >= 1
+	m.level = level
+
+	now := time.Now()
+	throttling := level >= slowdownLevel
+	if throttling && m.throttledSince.IsZero() {
+		m.throttledSince = now
+	} else if !throttling && !m.throttledSince.IsZero() {
+		m.throttled += now.Sub(m.throttledSince)
+		m.throttledSince = time.Time{}
+	}
+	m.mu.Unlock()
+
+	switch {
+	case level >= 1 && !paused:
+		log.Printf("System pressure is too high (%v), pausing scan.", reason)
+	case level < 1 && paused:
+		log.Printf("System pressure has fallen, resuming scan.")
+		m.resume.Broadcast()
+	}
+}
+
+// wait will block while scan is paused, and slow down n operations when pressure is close to the limit.
+func (m *pressureMonitor) wait(n int) {
+	m.mu.Lock()
+	for m.level >= 1 {
+		m.resume.Wait()
+	}
+	level := m.level
+	m.mu.Unlock()
+
+	if level > slowdownLevel {
+		time.Sleep(time.Duration(float64(n) * float64(slowdownDelay) * (level - slowdownLevel) / (1 - slowdownLevel)))
+	}
+}
+
+// throttledTime returns total time scan was slowed down or paused so far.
+func (m *pressureMonitor) throttledTime() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.throttledSince.IsZero() {
+		return m.throttled
+	}
+	return m.throttled + time.Since(m.throttledSince)
+}
+
+// parsePSI returns "some" avg10 percentage from pressure stall information, see
+// https://docs.kernel.org/accounting/psi.html.
+func parsePSI(data string) (float64, error) {
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "some" {
+			continue
+		}
+
+		var avg10 float64
+		if _, err := fmt.Sscanf(fields[1], "avg10=%g", &avg10); err != nil {
+			return 0, fmt.Errorf("invalid pressure stall information: %q", line)
+		}
+		return avg10, nil
+	}
+
+	return 0, fmt.Errorf("invalid pressure stall information: %q", data)
+}
@@ -0,0 +1,83 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParsePSI(t *testing.T) {
+	got, err := parsePSI("some avg10=12.50 avg60=3.37 avg300=4.44 total=161427174\n" +
+		"full avg10=1.00 avg60=0.00 avg300=0.00 total=0\n")
+	if err != nil || got != 12.5 {
+		t.Errorf("parsePSI() = %v, %v; want 12.5", got, err)
+	}
+
+	if _, err := parsePSI("full avg10=1.00\n"); err == nil {
+		t.Error("expected error without some line")
+	}
+}
+
+func TestPressureLevel(t *testing.T) {
+	limits := pressureLimits{io: 40, load: 8}
+	s := pressureSample{io: 10, cpu: 90, load: 6, hasIO: true, hasCPU: true}
+
+	// CPU pressure has no limit, load average is the closest to its limit
+	if level, reason := limits.level(s); level != 0.75 || reason != "load average is 6.00, limit is 8.00" {
+		t.Errorf("level() = %v, %q", level, reason)
+	}
+
+	if err := limits.check(pressureSample{}); err == nil {
+		t.Error("expected error checking I/O pressure limit without PSI")
+	}
+}
+
+func TestPressureMonitorPause(t *testing.T) {
+	m := &pressureMonitor{limits: pressureLimits{load: 1}}
+	m.resume = sync.NewCond(&m.mu)
+	m.update(pressureSample{load: 2})
+
+	done := make(chan struct{})
+	go func() {
+		m.wait(1)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("operation not paused while pressure is too high")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	m.update(pressureSample{load: 0.1})
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("operation not resumed after pressure has fallen")
+	}
+
+	if m.throttledTime() < 50*time.Millisecond {
+		t.Errorf("throttled for %v, want at least 50ms", m.throttledTime())
+	}
+}
@@ -0,0 +1,134 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// ioprio_set() and sched_setscheduler() constants, see ioprio_set(2) and sched(7).
+const (
+	ioprioWhoProcess  = 1
+	ioprioClassShift  = 13
+	ioprioClassBE     = 2
+	ioprioClassIdle   = 3
+	schedIdle         = 5
+	priorityMaxPasses = 5
+)
+
+// setPriority will set I/O scheduling class and level, nice value and SCHED_IDLE policy as requested. On Linux they
+// are per thread attributes, so they are set for every thread of the process. Threads created later inherit them.
+func setPriority(ioClass string, ioLevel, nice int, idle bool) error {
+	if ioClass == ioClassNone && nice == 0 && !idle {
+		return nil
+	}
+
+	ioprio := 0
+	switch ioClass {
+	case ioClassIdle:
+		ioprio = ioprioClassIdle << ioprioClassShift
+	case ioClassBestEffort:
+		ioprio = ioprioClassBE<<ioprioClassShift | ioLevel
+	}
+
+	// Threads started while setting priorities are picked up by the next pass
+	done := map[int]bool{}
+	for pass := 0; pass < priorityMaxPasses; pass++ {
+		tids, err := threadIDs()
+		if err != nil {
+			return err
+		}
+
+		changed := false
+		for _, tid := range tids {
+			if done[tid] {
+				continue
+			}
+			if err := setThreadPriority(tid, ioprio, nice, idle); err != nil {
+				return err
+			}
+			done[tid], changed = true, true
+		}
+		if !changed {
+			break
+		}
+	}
+
+	return nil
+}
+
+// setThreadPriority will set I/O priority, nice value and SCHED_IDLE policy of a single thread. Threads which have
+// exited in the meantime are ignored.
+func setThreadPriority(tid, ioprio, nice int, idle bool) error {
+	if ioprio != 0 {
+		_, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(ioprio))
+		if errno != 0 && errno != unix.ESRCH {
+			return fmt.Errorf("unable to set I/O priority: %w", errno)
+		}
+	}
+
+	if nice != 0 {
+		if err := unix.Setpriority(unix.PRIO_PROCESS, tid, nice); err != nil && err != unix.ESRCH {
+			return fmt.Errorf("unable to set nice value: %w", err)
+		}
+	}
+
+	if idle {
+		var param struct{ priority int32 }
+		_, _, errno := unix.Syscall(unix.SYS_SCHED_SETSCHEDULER, uintptr(tid), schedIdle, uintptr(unsafe.Pointer(&param)))
+		if errno != 0 && errno != unix.ESRCH {
+			return fmt.Errorf("unable to set SCHED_IDLE policy: %w", errno)
+		}
+	}
+
+	return nil
+}
+
+// threadIDs returns IDs of all threads of the process.
+func threadIDs() ([]int, error) {
+	f, err := os.Open("/proc/self/task")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	tids := make([]int, 0, len(names))
+	for _, name := range names {
+		if tid, err := strconv.Atoi(name); err == nil {
+			tids = append(tids, tid)
+		}
+	}
+	return tids, nil
+}
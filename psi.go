@@ -0,0 +1,66 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Linux pressure stall information and load average files.
+const (
+	psiIOPath   = "/proc/pressure/io"
+	psiCPUPath  = "/proc/pressure/cpu"
+	loadAvgPath = "/proc/loadavg"
+)
+
+// readSystemPressure returns current system pressure. PSI is optional, as it needs Linux >= 4.20 with PSI enabled.
+func readSystemPressure() (pressureSample, error) {
+	var s pressureSample
+	var err error
+
+	if data, readErr := os.ReadFile(psiIOPath); readErr == nil {
+		if s.io, err = parsePSI(string(data)); err != nil {
+			return s, err
+		}
+		s.hasIO = true
+	}
+	if data, readErr := os.ReadFile(psiCPUPath); readErr == nil {
+		if s.cpu, err = parsePSI(string(data)); err != nil {
+			return s, err
+		}
+		s.hasCPU = true
+	}
+
+	data, err := os.ReadFile(loadAvgPath)
+	if err != nil {
+		return s, err
+	}
+	if _, err := fmt.Sscanf(string(data), "%g", &s.load); err != nil {
+		return s, fmt.Errorf("invalid load average: %q", data)
+	}
+
+	return s, nil
+}
@@ -0,0 +1,65 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// rootReport is JSON report for a single root directory.
+type rootReport struct {
+	Root       string           `json:"root"`
+	Offenders  []offenderReport `json:"offenders"`
+	ErrorTotal int64            `json:"error_total"`
+	Errors     []walkErrorClass `json:"errors"`
+
+	Verification *verification `json:"verification,omitempty"`
+
+	// Incomplete is why walk was stopped before checking all directories, empty if it was not
+	Incomplete  string `json:"incomplete,omitempty"`
+	DirsChecked int64  `json:"directories_checked"`
+	DirsFound   int64  `json:"directories_found,omitempty"`
+
+	// Throttled is time in seconds the scan was slowed down or paused due to system pressure
+	Throttled float64 `json:"throttled,omitempty"`
+}
+
+// newRootReport returns JSON report of offenders and errors found in rootPath.
+func newRootReport(rootPath string, offenders []*offender, walkErrs *walkErrors) *rootReport {
+	r := &rootReport{
+		Root:       rootPath,
+		Offenders:  make([]offenderReport, 0, len(offenders)),
+		ErrorTotal: walkErrs.count(),
+		Errors:     walkErrs.summary(),
+	}
+	for _, o := range offenders {
+		r.Offenders = append(r.Offenders, o.toReport())
+	}
+
+	return r
+}
+
+// write will write report to standard output as a single line of JSON.
+func (r *rootReport) write() error {
+	return json.NewEncoder(os.Stdout).Encode(r)
+}
@@ -0,0 +1,107 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+// rootCandidate is a root directory given on command line.
+type rootCandidate struct {
+	pathname string
+	// resolved is absolute pathname with symlinks resolved when possible
+	resolved string
+	di       *dirInfo
+}
+
+// dedupeRoots will drop root directories given more than once and those inside other root directories, as walking
+// them would check the same directories again. Nested root directories across filesystem boundary are kept when not
+// crossing it. Root directories which can't be checked are kept, so that errors get reported.
+func dedupeRoots(roots []string) []string {
+	candidates := make([]rootCandidate, len(roots))
+	for i, r := range roots {
+		c := rootCandidate{pathname: r, resolved: r}
+		if abs, err := filepath.Abs(r); err == nil {
+			c.resolved = abs
+		}
+		if resolved, err := filepath.EvalSymlinks(c.resolved); err == nil {
+			c.resolved = resolved
+		}
+		c.di, _ = statPath(r, *followSymlinksFlag)
+		candidates[i] = c
+	}
+
+	deduped := make([]string, 0, len(roots))
+	for i, c := range candidates {
+		if covering, same := c.coveredBy(candidates, i); covering != nil {
+			if same {
+				log.Printf("Directory %q is the same directory as %q, skipping.", c.pathname, covering.pathname)
+			} else {
+				log.Printf("Directory %q is inside %q, skipping.", c.pathname, covering.pathname)
+			}
+			continue
+		}
+		deduped = append(deduped, c.pathname)
+	}
+
+	return deduped
+}
+
+// coveredBy returns root directory which will walk all directories of i-th candidate, and whether it is the same
+// directory, or nil if there is none. Of root directories given more than once, the first one is kept.
+func (c *rootCandidate) coveredBy(candidates []rootCandidate, i int) (*rootCandidate, bool) {
+	if c.di == nil {
+		return nil, false
+	}
+
+	for j := range candidates {
+		other := &candidates[j]
+		if j == i || other.di == nil {
+			continue
+		}
+
+		if c.di.ino != 0 && c.di.dev == other.di.dev && c.di.ino == other.di.ino {
+			if j < i {
+				return other, true
+			}
+			continue
+		}
+
+		if c.resolved != other.resolved && isSubpath(other.resolved, c.resolved) &&
+			(!*oneFilesystemFlag || !newFilesystemBoundary(*boundaryMode, other.resolved, other.di).crosses(c.resolved,
+				c.di)) {
+			return other, false
+		}
+	}
+
+	return nil, false
+}
+
+// isSubpath checks if pathname is the same as or inside parent.
+func isSubpath(parent, osPathname string) bool {
+	if strings.HasSuffix(parent, string(filepath.Separator)) {
+		return strings.HasPrefix(osPathname, parent)
+	}
+	return osPathname == parent || strings.HasPrefix(osPathname, parent+string(filepath.Separator))
+}
@@ -0,0 +1,82 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// Stall watchdog actions
+const (
+	stallSkip  = "skip"
+	stallAbort = "abort"
+)
+
+// errStall is the error of metadata calls which have not finished within stall timeout.
+var errStall = errors.New("metadata call stalled")
+
+// Guarded call states
+const (
+	callRunning int32 = iota
+	callFinished
+	callAbandoned
+)
+
+// guardCall will run a metadata call on osPathname, giving up on it once it takes longer than stall timeout, as
+// calls on stale network filesystems can block forever, and errStall is then returned. Abandoned call keeps running in
+// its own goroutine and release is called if it eventually succeeds, so that resources it acquired are released.
+// Results of fn must not be used if an error is returned. Without stall timeout fn is simply called.
+func guardCall(op, osPathname string, fn func() error, release func()) error {
+	if *stallTimeout <= 0 {
+		return fn()
+	}
+
+	state := callRunning
+	done := make(chan error, 1)
+	go func() {
+		err := fn()
+		if !atomic.CompareAndSwapInt32(&state, callRunning, callFinished) {
+			if err == nil && release != nil {
+				release()
+			}
+			return
+		}
+		done <- err
+	}()
+
+	timer := time.NewTimer(*stallTimeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		if !atomic.CompareAndSwapInt32(&state, callRunning, callAbandoned) {
+			return <-done
+		}
+		log.Printf("Call %v on %q has not finished in %v, abandoning it.", op, osPathname, *stallTimeout)
+		return errStall
+	}
+}
@@ -0,0 +1,62 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGuardCall(t *testing.T) {
+	saved := *stallTimeout
+	defer func() { *stallTimeout = saved }()
+
+	errFailed := errors.New("failed")
+	*stallTimeout = 0
+	if err := guardCall("stat", "/", func() error { return errFailed }, nil); err != errFailed {
+		t.Errorf("unguarded call returned %v, want %v", err, errFailed)
+	}
+
+	*stallTimeout = time.Second
+	if err := guardCall("stat", "/", func() error { return errFailed }, nil); err != errFailed {
+		t.Errorf("fast call returned %v, want %v", err, errFailed)
+	}
+
+	// Slow call is abandoned and released once it finishes
+	*stallTimeout = 10 * time.Millisecond
+	unblock := make(chan struct{})
+	released := make(chan struct{})
+	err := guardCall("stat", "/", func() error {
+		<-unblock
+		return nil
+	}, func() { close(released) })
+	if !errors.Is(err, errStall) {
+		t.Errorf("slow call returned %v, want %v", err, errStall)
+	}
+	close(unblock)
+	select {
+	case <-released:
+	case <-time.After(time.Second):
+		t.Error("abandoned call was not released")
+	}
+}
@@ -19,6 +19,7 @@
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
 // SOFTWARE.
 
+//go:build freebsd && amd64
 // +build freebsd,amd64
 
 package main
@@ -28,8 +29,24 @@ import (
 	"github.com/dkorunic/findlargedir/monkey"
 	"log"
 	"os"
+	"syscall"
 )
 
+// detectIsilonStat checks if the running kernel speaks Isilon OneFS 7.x stat ABI.
+func detectIsilonStat() bool {
+	return isilonstat.DetectABI() == isilonstat.ABIOneFS7
+}
+
+// canPatchSyscallStat checks if os.Stat and os.Lstat can be monkey patched on this system.
+func canPatchSyscallStat() error {
+	for _, f := range []interface{}{os.Stat, os.Lstat, syscall.Getdirentries} {
+		if err := monkey.CanPatch(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // patchSyscallStat will attempt to monkey patch syscall.Stat with our Isilon version.
 func patchSyscallStat() {
 	log.Print("Attempting to monkey patch syscall.Stat. We might horribly crash here...")
@@ -43,3 +60,10 @@ func patchSyscallLstat() {
 	monkey.Patch(os.Lstat, isilonstat.Lstat)
 	log.Print("Patching syscall.Lstat done.")
 }
+
+// patchSyscallGetdirentries will attempt to monkey patch syscall.Getdirentries with our Isilon version.
+func patchSyscallGetdirentries() {
+	log.Print("Attempting to monkey patch syscall.Getdirentries. We might horribly crash here...")
+	monkey.Patch(syscall.Getdirentries, isilonstat.Getdirentries)
+	log.Print("Patching syscall.Getdirentries done.")
+}
@@ -19,10 +19,26 @@
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
 // SOFTWARE.
 
+//go:build !freebsd || !amd64
 // +build !freebsd !amd64
 
 package main
 
+import (
+	"fmt"
+	"runtime"
+)
+
+// detectIsilonStat always returns false as Isilon stat_t structure is FreeBSD/amd64 specific.
+func detectIsilonStat() bool {
+	return false
+}
+
+// canPatchSyscallStat always fails as Isilon stat_t structure is FreeBSD/amd64 specific.
+func canPatchSyscallStat() error {
+	return fmt.Errorf("isilon mode is not supported on %v/%v", runtime.GOOS, runtime.GOARCH)
+}
+
 // patchSyscallStat s just a dummy function.
 func patchSyscallStat() {
 	// do nothing
@@ -32,3 +48,8 @@ func patchSyscallStat() {
 func patchSyscallLstat() {
 	// do nothing
 }
+
+// patchSyscallGetdirentries s just a dummy function.
+func patchSyscallGetdirentries() {
+	// do nothing
+}
@@ -0,0 +1,113 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"os"
+	"sync/atomic"
+
+	"golang.org/x/sys/unix"
+)
+
+// statxMask requests only attributes needed for directory checks and subdirectory estimates, as opposed to full stat.
+const statxMask = unix.STATX_TYPE | unix.STATX_SIZE | unix.STATX_INO | unix.STATX_NLINK | unix.STATX_MNT_ID
+
+// statxUnsupported is set once statx() turns out to be missing (Linux < 4.11).
+var statxUnsupported int32
+
+// statAt returns dirInfo of name relative to dirfd, or of dirfd itself if name
+// is empty. It follows symlinks only if requested. It prefers statx() and falls back to fstatat().
+func statAt(dirfd int, name string, follow bool) (*dirInfo, error) {
+	if atomic.LoadInt32(&statxUnsupported) == 0 {
+		flags := statxFlags(follow)
+		if name == "" {
+			flags |= unix.AT_EMPTY_PATH
+		}
+
+		var stx unix.Statx_t
+		err := unix.Statx(dirfd, name, flags, statxMask, &stx)
+		if err == nil {
+			return statxToDirInfo(&stx), nil
+		}
+		if err != unix.ENOSYS {
+			return nil, err
+		}
+		atomic.StoreInt32(&statxUnsupported, 1)
+	}
+
+	var st unix.Stat_t
+	var err error
+	if name == "" {
+		err = unix.Fstat(dirfd, &st)
+	} else {
+		err = unix.Fstatat(dirfd, name, &st, fstatatFlags(follow))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return statToDirInfo(&st), nil
+}
+
+// statxFlags returns statx() flags: follow symlinks only if requested and optionally use cached attributes.
+func statxFlags(follow bool) int {
+	flags := fstatatFlags(follow)
+	if *noSyncFlag {
+		flags |= unix.AT_STATX_DONT_SYNC
+	}
+	return flags
+}
+
+// statPath returns dirInfo of a pathname, following symlinks only if requested.
+func statPath(osPathname string, follow bool) (*dirInfo, error) {
+	metadataThrottle.wait(1)
+
+	var di *dirInfo
+	err := guardCall("statx", osPathname, func() (err error) {
+		di, err = statAt(unix.AT_FDCWD, osPathname, follow)
+		return err
+	}, nil)
+	if err != nil {
+		return nil, &os.PathError{Op: "statx", Path: osPathname, Err: err}
+	}
+	return di, nil
+}
+
+// statxToDirInfo converts statx() results to dirInfo. Mount ID is used only if kernel returned it (Linux >= 5.8), and
+// link count is left unknown (zero) unless it did.
+func statxToDirInfo(stx *unix.Statx_t) *dirInfo {
+	di := &dirInfo{
+		size:     int64(stx.Size),
+		dev:      unix.Mkdev(stx.Dev_major, stx.Dev_minor),
+		ino:      stx.Ino,
+		mntID:    stx.Mnt_id,
+		hasMntID: stx.Mask&unix.STATX_MNT_ID != 0,
+		dir:      stx.Mode&unix.S_IFMT == unix.S_IFDIR,
+	}
+	if stx.Mask&unix.STATX_NLINK != 0 {
+		di.nlink = uint64(stx.Nlink)
+	}
+	return di
+}
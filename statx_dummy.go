@@ -0,0 +1,52 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"os"
+)
+
+// statPath returns dirInfo of a pathname, following symlinks only if requested.
+func statPath(osPathname string, follow bool) (*dirInfo, error) {
+	metadataThrottle.wait(1)
+
+	stat := os.Lstat
+	if follow {
+		stat = os.Stat
+	}
+
+	var fi os.FileInfo
+	err := guardCall("stat", osPathname, func() (err error) {
+		fi, err = stat(osPathname)
+		return err
+	}, nil)
+	if err == errStall {
+		return nil, &os.PathError{Op: "stat", Path: osPathname, Err: err}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fileInfoToDirInfo(fi), nil
+}
@@ -0,0 +1,128 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// opsThrottle paces metadata operations of all goroutines to a maximum rate, and slows them down or pauses them
+// while system pressure is too high. Operations are scheduled at fixed intervals, so that time lost oversleeping is
+// made up by the following operations.
+type opsThrottle struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+	pressure *pressureMonitor
+}
+
+// newOpsThrottle returns throttle for given number of operations per second and pressure monitor, or nil if there is
+// neither.
+func newOpsThrottle(rate int64, pressure *pressureMonitor) *opsThrottle {
+	if rate <= 0 && pressure == nil {
+		return nil
+	}
+
+	t := &opsThrottle{pressure: pressure}
+	if rate > 0 {
+		t.interval = time.Second / time.Duration(rate)
+	}
+	return t
+}
+
+// wait will block until n more operations are allowed. It does nothing on nil throttle.
+func (t *opsThrottle) wait(n int) {
+	if t == nil || n <= 0 {
+		return
+	}
+	if t.pressure != nil {
+		t.pressure.wait(n)
+	}
+	if t.interval == 0 {
+		return
+	}
+
+	t.mu.Lock()
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	at := t.next
+	t.next = t.next.Add(time.Duration(n) * t.interval)
+	t.mu.Unlock()
+
+	if d := time.Until(at); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// throttledTime returns total time operations were slowed down or paused due to system pressure.
+func (t *opsThrottle) throttledTime() time.Duration {
+	if t == nil || t.pressure == nil {
+		return 0
+	}
+	return t.pressure.throttledTime()
+}
+
+// removeAllThrottled will remove a directory tree as os.RemoveAll does, pacing removal of each entry by metadata
+// throttle and giving up on stalled calls.
+func removeAllThrottled(dir string) error {
+	if metadataThrottle == nil && *stallTimeout <= 0 {
+		return os.RemoveAll(dir)
+	}
+
+	var names []string
+	err := guardCall("readdir", dir, func() (err error) {
+		names, err = readNames(dir)
+		return err
+	}, nil)
+	if err == errStall {
+		return &os.PathError{Op: "readdir", Path: dir, Err: err}
+	}
+	for _, name := range names {
+		metadataThrottle.wait(1)
+		path := filepath.Join(dir, name)
+		err := guardCall("unlink", path, func() error {
+			return os.Remove(path)
+		}, nil)
+		if err == errStall {
+			return &os.PathError{Op: "unlink", Path: path, Err: err}
+		}
+		if err != nil {
+			// Most likely a directory which is not empty
+			if err := removeAllThrottled(path); err != nil {
+				return err
+			}
+		}
+	}
+
+	err = guardCall("rmdir", dir, func() error {
+		return os.RemoveAll(dir)
+	}, nil)
+	if err == errStall {
+		return &os.PathError{Op: "rmdir", Path: dir, Err: err}
+	}
+	return err
+}
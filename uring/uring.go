@@ -0,0 +1,353 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+//go:build linux
+// +build linux
+
+// Package uring provides a minimal io_uring interface: just enough to batch
+// statx() and openat() calls. Each batch is submitted and fully reaped before
+// the next one is prepared, so there is no completion queue overflow handling.
+package uring
+
+import (
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Supported io_uring operations.
+const (
+	OpOpenat = 18 // IORING_OP_OPENAT, Linux >= 5.6
+	OpStatx  = 21 // IORING_OP_STATX, Linux >= 5.6
+)
+
+const (
+	offSQRing = 0
+	offCQRing = 0x8000000
+	offSQEs   = 0x10000000
+
+	featSingleMmap = 1 << 0
+	enterGetEvents = 1 << 0
+	registerProbe  = 8
+	opSupported    = 1 << 0
+	probeOps       = 256
+)
+
+// sqringOffsets is struct io_sqring_offsets.
+type sqringOffsets struct {
+	head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+	userAddr                                                        uint64
+}
+
+// cqringOffsets is struct io_cqring_offsets.
+type cqringOffsets struct {
+	head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+	userAddr                                                        uint64
+}
+
+// params is struct io_uring_params.
+type params struct {
+	sqEntries, cqEntries, flags, sqThreadCPU, sqThreadIdle, features, wqFd uint32
+	resv                                                                   [3]uint32
+	sqOff                                                                  sqringOffsets
+	cqOff                                                                  cqringOffsets
+}
+
+// sqe is struct io_uring_sqe.
+type sqe struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	opFlags     uint32
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFdIn  int32
+	addr3       uint64
+	pad         uint64
+}
+
+// cqe is struct io_uring_cqe.
+type cqe struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// probe is struct io_uring_probe followed by probeOps struct io_uring_probe_op.
+type probe struct {
+	lastOp uint8
+	opsLen uint8
+	resv   uint16
+	resv2  [3]uint32
+	ops    [probeOps]struct {
+		op    uint8
+		resv  uint8
+		flags uint16
+		resv2 uint32
+	}
+}
+
+// A Ring is an io_uring instance. It is not safe for concurrent use.
+type Ring struct {
+	fd     int
+	sqRing []byte
+	cqRing []byte
+	sqes   []sqe
+
+	sqHead, sqTail, sqMask *uint32
+	sqArray                []uint32
+	cqHead, cqTail, cqMask *uint32
+	cqes                   []cqe
+
+	pending uint32
+}
+
+// New creates a Ring with at least entries submission queue entries.
+func New(entries uint32) (*Ring, error) {
+	var p params
+	fd, _, errno := unix.Syscall(unix.SYS_IO_URING_SETUP, uintptr(entries), uintptr(unsafe.Pointer(&p)), 0)
+	if errno != 0 {
+		return nil, fmt.Errorf("io_uring_setup: %w", errno)
+	}
+	r := &Ring{fd: int(fd)}
+
+	if err := r.mmap(&p); err != nil {
+		r.Close()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// mmap maps submission and completion rings and submission queue entries.
+func (r *Ring) mmap(p *params) (err error) {
+	sqSize := int(p.sqOff.array + p.sqEntries*4)
+	cqSize := int(p.cqOff.cqes + p.cqEntries*uint32(unsafe.Sizeof(cqe{})))
+	if p.features&featSingleMmap != 0 && cqSize > sqSize {
+		sqSize = cqSize
+	}
+
+	r.sqRing, err = unix.Mmap(r.fd, offSQRing, sqSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		return fmt.Errorf("io_uring mmap: %w", err)
+	}
+
+	r.cqRing = r.sqRing
+	if p.features&featSingleMmap == 0 {
+		r.cqRing, err = unix.Mmap(r.fd, offCQRing, cqSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+		if err != nil {
+			return fmt.Errorf("io_uring mmap: %w", err)
+		}
+	}
+
+	sqesRing, err := unix.Mmap(r.fd, offSQEs, int(p.sqEntries)*int(unsafe.Sizeof(sqe{})),
+		unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		return fmt.Errorf("io_uring mmap: %w", err)
+	}
+	r.sqes = unsafe.Slice((*sqe)(unsafe.Pointer(&sqesRing[0])), p.sqEntries)
+
+	r.sqHead = (*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.head]))
+	r.sqTail = (*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.tail]))
+	r.sqMask = (*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.ringMask]))
+	r.sqArray = unsafe.Slice((*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.array])), p.sqEntries)
+
+	r.cqHead = (*uint32)(unsafe.Pointer(&r.cqRing[p.cqOff.head]))
+	r.cqTail = (*uint32)(unsafe.Pointer(&r.cqRing[p.cqOff.tail]))
+	r.cqMask = (*uint32)(unsafe.Pointer(&r.cqRing[p.cqOff.ringMask]))
+	r.cqes = unsafe.Slice((*cqe)(unsafe.Pointer(&r.cqRing[p.cqOff.cqes])), p.cqEntries)
+
+	return nil
+}
+
+// Close unmaps all rings and closes io_uring descriptor.
+func (r *Ring) Close() error {
+	if r.sqes != nil {
+		_ = unix.Munmap(unsafe.Slice((*byte)(unsafe.Pointer(&r.sqes[0])), len(r.sqes)*int(unsafe.Sizeof(sqe{}))))
+	}
+	if r.cqRing != nil && &r.cqRing[0] != &r.sqRing[0] {
+		_ = unix.Munmap(r.cqRing)
+	}
+	if r.sqRing != nil {
+		_ = unix.Munmap(r.sqRing)
+	}
+	return unix.Close(r.fd)
+}
+
+// Entries returns submission queue size, the largest possible batch.
+func (r *Ring) Entries() int {
+	return len(r.sqes)
+}
+
+// Probe checks if all ops are supported by the running kernel (Linux >= 5.6).
+func (r *Ring) Probe(ops ...uint8) error {
+	var p probe
+	_, _, errno := unix.Syscall6(unix.SYS_IO_URING_REGISTER, uintptr(r.fd), registerProbe,
+		uintptr(unsafe.Pointer(&p)), probeOps, 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("io_uring_register: %w", errno)
+	}
+
+	for _, op := range ops {
+		if op > p.lastOp || p.ops[op].flags&opSupported == 0 {
+			return fmt.Errorf("io_uring operation %v is not supported", op)
+		}
+	}
+
+	return nil
+}
+
+// nextSQE returns next free submission queue entry or nil if the queue is full.
+func (r *Ring) nextSQE() *sqe {
+	tail := atomic.LoadUint32(r.sqTail)
+	if tail-atomic.LoadUint32(r.sqHead) >= uint32(len(r.sqes)) {
+		return nil
+	}
+
+	idx := tail & *r.sqMask
+	e := &r.sqes[idx]
+	*e = sqe{}
+	r.sqArray[idx] = idx
+	atomic.StoreUint32(r.sqTail, tail+1)
+	r.pending++
+
+	return e
+}
+
+// PrepareStatx queues statx(dirfd, path, flags, mask, stx). Path has to be
+// NUL-terminated. Both path and stx have to stay alive until Wait returns.
+// Returns false if the submission queue is full.
+func (r *Ring) PrepareStatx(dirfd int, path *byte, flags, mask int, stx *unix.Statx_t, userData uint64) bool {
+	e := r.nextSQE()
+	if e == nil {
+		return false
+	}
+
+	e.opcode = OpStatx
+	e.fd = int32(dirfd)
+	e.addr = uint64(uintptr(unsafe.Pointer(path)))
+	e.len = uint32(mask)
+	e.off = uint64(uintptr(unsafe.Pointer(stx)))
+	e.opFlags = uint32(flags)
+	e.userData = userData
+
+	return true
+}
+
+// PrepareOpenat queues openat(dirfd, path, flags, mode). Path has to be
+// NUL-terminated and has to stay alive until Wait returns. Returns false if the
+// submission queue is full.
+func (r *Ring) PrepareOpenat(dirfd int, path *byte, flags int, mode uint32, userData uint64) bool {
+	e := r.nextSQE()
+	if e == nil {
+		return false
+	}
+
+	e.opcode = OpOpenat
+	e.fd = int32(dirfd)
+	e.addr = uint64(uintptr(unsafe.Pointer(path)))
+	e.len = mode
+	e.opFlags = uint32(flags)
+	e.userData = userData
+
+	return true
+}
+
+// Wait submits all prepared entries, waits for all of them to complete and
+// calls fn for each completion with its user data and result. Negative result
+// is an errno.
+//
+// If submission fails, entries the kernel has not taken yet are discarded and
+// never complete, while those already submitted are still waited for, so that
+// the kernel no longer uses their buffers or opens descriptors once Wait
+// returns. Only if even waiting fails some entries remain in flight, see
+// Pending.
+func (r *Ring) Wait(fn func(userData uint64, res int32)) error {
+	err := r.submit()
+	if reapErr := r.reap(fn); reapErr != nil {
+		return reapErr
+	}
+
+	return err
+}
+
+// Pending returns the number of entries prepared or in flight. It is zero
+// after Wait unless Wait failed waiting for completions.
+func (r *Ring) Pending() int {
+	return int(r.pending)
+}
+
+// submit submits all prepared entries. On failure it discards entries not
+// submitted yet, which the kernel reads only within io_uring_enter.
+func (r *Ring) submit() error {
+	for {
+		head, tail := atomic.LoadUint32(r.sqHead), atomic.LoadUint32(r.sqTail)
+		if head == tail {
+			return nil
+		}
+
+		n, _, errno := unix.Syscall6(unix.SYS_IO_URING_ENTER, uintptr(r.fd), uintptr(tail-head), 0, 0, 0, 0)
+		if errno == syscall.EINTR {
+			continue
+		}
+		if errno == 0 && n == 0 {
+			errno = syscall.EAGAIN
+		}
+		if errno != 0 {
+			head = atomic.LoadUint32(r.sqHead)
+			r.pending -= tail - head
+			atomic.StoreUint32(r.sqTail, head)
+			return fmt.Errorf("io_uring_enter: %w", errno)
+		}
+	}
+}
+
+// reap waits for completions of all submitted entries, retrying on transient
+// errors.
+func (r *Ring) reap(fn func(userData uint64, res int32)) error {
+	for r.pending > 0 {
+		head := atomic.LoadUint32(r.cqHead)
+		if head == atomic.LoadUint32(r.cqTail) {
+			_, _, errno := unix.Syscall6(unix.SYS_IO_URING_ENTER, uintptr(r.fd), 0, 1, enterGetEvents, 0, 0)
+			if errno != 0 && errno != syscall.EINTR && errno != syscall.EAGAIN && errno != syscall.EBUSY {
+				return fmt.Errorf("io_uring_enter: %w", errno)
+			}
+			continue
+		}
+
+		c := r.cqes[head&*r.cqMask]
+		atomic.StoreUint32(r.cqHead, head+1)
+		r.pending--
+		fn(c.userData, c.res)
+	}
+	runtime.KeepAlive(r)
+
+	return nil
+}
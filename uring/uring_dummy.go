@@ -0,0 +1,45 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+//go:build !linux
+// +build !linux
+
+// Package uring provides a minimal io_uring interface: just enough to batch
+// statx() and openat() calls. Each batch is submitted and fully reaped before
+// the next one is prepared, so there is no completion queue overflow handling.
+package uring
+
+import (
+	"errors"
+)
+
+// A Ring is an io_uring instance, only available on Linux.
+type Ring struct{}
+
+// New always fails as io_uring is Linux specific.
+func New(entries uint32) (*Ring, error) {
+	return nil, errors.New("io_uring is only available on Linux")
+}
+
+// Close does nothing.
+func (r *Ring) Close() error {
+	return nil
+}
@@ -0,0 +1,230 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"log"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Directories with best estimate of at least this fraction of alert threshold are verification candidates
+const nearThresholdFraction = 0.5
+
+// verifyCandidate is a directory which may be exact-counted to verify its estimate.
+type verifyCandidate struct {
+	pathname string
+	estimate int64
+	// excess is directory inode size above the empty directory size
+	excess float64
+	// modelRatio is calibrated ratio for directory file name length, before refinement
+	modelRatio float64
+}
+
+// verifySample is an exact-counted verification candidate.
+type verifySample struct {
+	Path      string `json:"path"`
+	Estimated int64  `json:"estimated"`
+	Exact     int64  `json:"exact"`
+}
+
+// verification is the result of verifying a sample of estimates.
+type verification struct {
+	Samples     []verifySample `json:"samples"`
+	MeanError   float64        `json:"mean_error"`
+	MaxError    float64        `json:"max_error"`
+	RatioFactor float64        `json:"ratio_factor"`
+}
+
+// reservoirEntry is a verification candidate in the reservoir, with its exact count once known.
+type reservoirEntry struct {
+	candidate verifyCandidate
+	// id tells apart candidates which replaced each other while being counted
+	id       int64
+	counting bool
+	counted  bool
+	exact    int64
+}
+
+// verifier exact-counts a random sample of candidates while walking, and refines the ratio from the results.
+// Candidates are kept in a reservoir sampled pool of limit entries, so that when the walk is done it holds a uniform
+// random sample of all candidates, whichever part of the tree they came from. Candidates are counted as soon as they
+// enter the pool, and counts of those replaced later still refine the ratio, but only the final pool is reported.
+type verifier struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	limit     int
+	reservoir []reservoirEntry
+	offered   int64
+	closed    bool
+	rnd       *rand.Rand
+	sumRatio  float64
+	sumModel  float64
+}
+
+// newVerifier returns verifier of a sample of at most limit directories.
+func newVerifier(limit int) *verifier {
+	v := &verifier{limit: limit, rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	v.cond = sync.NewCond(&v.mu)
+	return v
+}
+
+// offer will add a verification candidate to the reservoir, or replace a random one in it with probability of limit
+// to the number of candidates offered so far.
+func (v *verifier) offer(c verifyCandidate) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.offered++
+	e := reservoirEntry{candidate: c, id: v.offered}
+	if len(v.reservoir) < v.limit {
+		v.reservoir = append(v.reservoir, e)
+	} else if i := v.rnd.Int63n(v.offered); i < int64(v.limit) {
+		v.reservoir[i] = e
+	} else {
+		return
+	}
+	v.cond.Signal()
+}
+
+// close will stop accepting candidates, run returns once all of the reservoir is counted.
+func (v *verifier) close() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.closed = true
+	v.cond.Signal()
+}
+
+// next will pick a candidate in the reservoir to count, waiting for one if needed.
+func (v *verifier) next() (verifyCandidate, int64, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for {
+		for i := range v.reservoir {
+			if e := &v.reservoir[i]; !e.counting && !e.counted {
+				e.counting = true
+				return e.candidate, e.id, true
+			}
+		}
+		if v.closed {
+			return verifyCandidate{}, 0, false
+		}
+		v.cond.Wait()
+	}
+}
+
+// run will exact-count candidates using count until verifier is closed and all of the reservoir is counted.
+func (v *verifier) run(count func(osPathname string) (dirCount, error)) {
+	for {
+		c, id, ok := v.next()
+		if !ok {
+			return
+		}
+
+		exact, err := count(c.pathname)
+		v.mu.Lock()
+		for i := range v.reservoir {
+			if e := &v.reservoir[i]; e.id == id {
+				if err != nil {
+					// Unable to count, so it is no longer a candidate
+					v.reservoir = append(v.reservoir[:i], v.reservoir[i+1:]...)
+				} else {
+					e.counting, e.counted, e.exact = false, true, exact.entries
+				}
+				break
+			}
+		}
+		if err == nil {
+			v.sumRatio += c.excess
+			v.sumModel += float64(exact.entries) * c.modelRatio
+		}
+		v.mu.Unlock()
+
+		if err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+// factor returns refined ratio to calibrated ratio factor observed so far.
+func (v *verifier) factor() float64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.sumRatio <= 0 || v.sumModel <= 0 {
+		return 1
+	}
+	return v.sumRatio / v.sumModel
+}
+
+// result returns verification result, or nil if nothing was verified.
+func (v *verifier) result() *verification {
+	v.mu.Lock()
+	var samples []verifySample
+	for _, e := range v.reservoir {
+		if e.counted {
+			samples = append(samples, verifySample{Path: e.candidate.pathname, Estimated: e.candidate.estimate,
+				Exact: e.exact})
+		}
+	}
+	v.mu.Unlock()
+
+	if len(samples) == 0 {
+		return nil
+	}
+
+	r := &verification{Samples: samples, RatioFactor: v.factor()}
+	for _, s := range samples {
+		e := relativeError(s.Estimated, s.Exact)
+		r.MeanError += e / float64(len(samples))
+		r.MaxError = math.Max(r.MaxError, e)
+	}
+
+	return r
+}
+
+// report will log verification result.
+func (v *verifier) report(rootPath string) {
+	r := v.result()
+	if r == nil {
+		log.Printf("No directories in %q were verified.", rootPath)
+		return
+	}
+
+	log.Printf("Verified %v directories in %q: mean estimation error is %.1f%%, largest %.1f%%, ratio refined by factor %.3f.",
+		len(r.Samples), rootPath, r.MeanError*100, r.MaxError*100, r.RatioFactor)
+}
+
+// relativeError returns absolute estimation error relative to exact count.
+func relativeError(estimate, exact int64) float64 {
+	if exact == 0 {
+		if estimate == 0 {
+			return 0
+		}
+		return 1
+	}
+	return math.Abs(float64(estimate-exact)) / float64(exact)
+}
@@ -0,0 +1,83 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestVerifier(t *testing.T) {
+	v := newVerifier(2)
+	exact := map[string]int64{"/a": 1000, "/b": 3000, "/c": 2000}
+
+	// Model ratio is 20, but directories really use 40 bytes per entry
+	for _, path := range []string{"/a", "/b", "/c"} {
+		v.offer(verifyCandidate{pathname: path, estimate: exact[path] * 2, excess: float64(exact[path] * 40),
+			modelRatio: 20})
+	}
+	v.close()
+	v.run(func(osPathname string) (dirCount, error) {
+		return dirCount{entries: exact[osPathname]}, nil
+	})
+
+	r := v.result()
+	if r == nil || len(r.Samples) != 2 {
+		t.Fatalf("result() = %+v; want 2 samples", r)
+	}
+	if r.RatioFactor != 2 || v.factor() != 2 {
+		t.Errorf("RatioFactor = %v; want 2", r.RatioFactor)
+	}
+	if math.Abs(r.MeanError-1) > 1e-9 || math.Abs(r.MaxError-1) > 1e-9 {
+		t.Errorf("MeanError = %v, MaxError = %v; want 1", r.MeanError, r.MaxError)
+	}
+}
+
+func TestVerifierSample(t *testing.T) {
+	// Sample should come from the whole walk, not only from its beginning
+	var late, total int
+	for trial := 0; trial < 100; trial++ {
+		v := newVerifier(10)
+		for i := 0; i < 1000; i++ {
+			v.offer(verifyCandidate{pathname: strconv.Itoa(i), estimate: 100})
+		}
+		v.close()
+		v.run(func(osPathname string) (dirCount, error) {
+			return dirCount{entries: 100}, nil
+		})
+
+		for _, s := range v.result().Samples {
+			if i, _ := strconv.Atoi(s.Path); i >= 500 {
+				late++
+			}
+			total++
+		}
+	}
+
+	if total != 1000 {
+		t.Fatalf("got %v samples; want 1000", total)
+	}
+	if late < 400 || late > 600 {
+		t.Errorf("got %v of %v samples from the second half of candidates; want about half", late, total)
+	}
+}
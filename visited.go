@@ -0,0 +1,54 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+// dirKey identifies a directory by device and inode number.
+type dirKey struct {
+	dev uint64
+	ino uint64
+}
+
+// visitedDirs is a set of directories already checked, used to break symlink loops and to avoid checking the same
+// directory twice when it is reachable through several paths.
+type visitedDirs map[dirKey]struct{}
+
+// has checks if a directory has been visited.
+func (v visitedDirs) has(di *dirInfo) bool {
+	_, ok := v[dirKey{dev: di.dev, ino: di.ino}]
+	return ok
+}
+
+// visit will mark a directory as visited, returning false if it already was. Directories without inode numbers, as
+// on Windows, are always visited.
+func (v visitedDirs) visit(di *dirInfo) bool {
+	if di.ino == 0 {
+		return true
+	}
+
+	key := dirKey{dev: di.dev, ino: di.ino}
+	if _, ok := v[key]; ok {
+		return false
+	}
+	v[key] = struct{}{}
+
+	return true
+}
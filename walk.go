@@ -0,0 +1,144 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// dirInfo is directory metadata needed to estimate its entry count and to
+// detect filesystem boundaries.
+type dirInfo struct {
+	size     int64
+	dev      uint64
+	ino      uint64
+	nlink    uint64 // zero when unknown
+	mntID    uint64
+	hasMntID bool
+	dir      bool
+}
+
+// dirCount is exact number of entries in a directory.
+type dirCount struct {
+	entries int64
+	subdirs int64
+}
+
+// errStopWalk is returned by walkFunc to stop the whole walk.
+var errStopWalk = errors.New("walk stopped")
+
+// walkFunc is called for every directory found, including the root. Returning
+// filepath.SkipDir skips directory contents and errStopWalk stops the walk, any
+// other error is passed on to walkErrorFunc and the directory contents are
+// skipped as well.
+type walkFunc func(osPathname string, di *dirInfo) error
+
+// walkErrorFunc is called for errors encountered while walking, walk always
+// continues with the remaining directories.
+type walkErrorFunc func(osPathname string, err error)
+
+// walkNameFunc is called with name length of every entry read while walking,
+// including files.
+type walkNameFunc func(nameLength int)
+
+// walkDoneFunc is called for a directory once it and all of its subdirectories
+// have been walked, unless the walk was stopped.
+type walkDoneFunc func(osPathname string)
+
+// walkOptions are options for a single directory tree walk.
+type walkOptions struct {
+	callback      walkFunc
+	errorCallback walkErrorFunc
+	nameCallback  walkNameFunc
+	doneCallback  walkDoneFunc
+
+	// followSymlinks makes engines walk symlinks to directories as well
+	followSymlinks bool
+
+	// stopped is set once walkFunc returns errStopWalk, engines check it to
+	// stop walking
+	stopped bool
+}
+
+// walkEngine walks directory trees and counts directory entries.
+type walkEngine interface {
+	// walk will call opts.callback for every directory in a tree rooted at rootPath.
+	walk(rootPath string, opts *walkOptions) error
+	// countEntries will return exact number of entries and subdirectories in a directory.
+	countEntries(osPathname string) (dirCount, error)
+}
+
+// walkEngines holds all engines available on this platform.
+var walkEngines = map[string]walkEngine{}
+
+// getWalkEngine returns walk engine by name.
+func getWalkEngine(name string) (walkEngine, error) {
+	if e, ok := walkEngines[name]; ok {
+		return e, nil
+	}
+
+	names := make([]string, 0, len(walkEngines))
+	for n := range walkEngines {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	return nil, fmt.Errorf("unknown walk engine %q, available engines are: %v", name, strings.Join(names, ", "))
+}
+
+// handleWalkError will call walkErrorFunc for err unless it is filepath.SkipDir,
+// or mark the walk as stopped for errStopWalk.
+func handleWalkError(opts *walkOptions, osPathname string, err error) {
+	if err == errStopWalk {
+		opts.stopped = true
+		return
+	}
+	if err != filepath.SkipDir && opts.errorCallback != nil {
+		opts.errorCallback(osPathname, err)
+	}
+}
+
+// isDirPath checks if pathname is a directory, following symlinks. Error is returned only for stalled calls, as other
+// failures, like dangling symlinks, just mean it is not a directory.
+func isDirPath(osPathname string) (bool, error) {
+	var fi os.FileInfo
+	err := guardCall("stat", osPathname, func() (err error) {
+		fi, err = os.Stat(osPathname)
+		return err
+	}, nil)
+	if err == errStall {
+		return false, &os.PathError{Op: "stat", Path: osPathname, Err: err}
+	}
+	return err == nil && fi.IsDir(), nil
+}
+
+// handleWalkDone will call walkDoneFunc for a walked directory unless the walk was stopped.
+func handleWalkDone(opts *walkOptions, osPathname string) {
+	if !opts.stopped && opts.doneCallback != nil {
+		opts.doneCallback(osPathname)
+	}
+}
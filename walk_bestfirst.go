@@ -0,0 +1,149 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"container/heap"
+	"os"
+	"path/filepath"
+
+	"github.com/karrick/godirwalk"
+)
+
+// bestFirstEngine is a portable walk engine which reads directories with the largest inode size first, so that the
+// biggest directories are checked early on. Pending directories are kept by pathname in a priority queue, which
+// takes more memory than depth first walking on trees with many directories.
+type bestFirstEngine struct{}
+
+func init() {
+	walkEngines["bestfirst"] = bestFirstEngine{}
+}
+
+// pendingDir is a checked directory waiting to be read. It stays pending until it has been read and all of its
+// subdirectories are done, so that the walk can tell when its subtree is done.
+type pendingDir struct {
+	osPathname string
+	size       int64
+	parent     *pendingDir
+	pending    int
+}
+
+// release will drop one pending reason of a directory, calling walkDoneFunc for it and its parents once they are
+// done.
+func (d *pendingDir) release(opts *walkOptions) {
+	for ; d != nil; d = d.parent {
+		d.pending--
+		if d.pending > 0 {
+			return
+		}
+		handleWalkDone(opts, d.osPathname)
+	}
+}
+
+// pendingDirs is a max-heap of pending directories by inode size.
+type pendingDirs []*pendingDir
+
+func (p pendingDirs) Len() int            { return len(p) }
+func (p pendingDirs) Less(i, j int) bool  { return p[i].size > p[j].size }
+func (p pendingDirs) Swap(i, j int)       { p[i], p[j] = p[j], p[i] }
+func (p *pendingDirs) Push(x interface{}) { *p = append(*p, x.(*pendingDir)) }
+
+func (p *pendingDirs) Pop() interface{} {
+	old := *p
+	d := old[len(old)-1]
+	*p = old[:len(old)-1]
+	return d
+}
+
+func (bestFirstEngine) walk(rootPath string, opts *walkOptions) error {
+	queue := &pendingDirs{}
+
+	// check will call the callback and queue the directory to be read unless it is skipped.
+	check := func(osPathname string, parent *pendingDir) {
+		di, err := statPath(osPathname, opts.followSymlinks)
+		if err != nil {
+			handleWalkError(opts, osPathname, err)
+			return
+		}
+
+		if err := opts.callback(osPathname, di); err != nil {
+			handleWalkError(opts, osPathname, err)
+			return
+		}
+		if parent != nil {
+			parent.pending++
+		}
+		heap.Push(queue, &pendingDir{osPathname: osPathname, size: di.size, parent: parent, pending: 1})
+	}
+
+	check(rootPath, nil)
+
+	scratch := make([]byte, godirwalk.MinimumScratchBufferSize)
+	for queue.Len() > 0 && !opts.stopped {
+		d := heap.Pop(queue).(*pendingDir)
+
+		metadataThrottle.wait(1)
+		var children godirwalk.Dirents
+		err := guardCall("readdir", d.osPathname, func() (err error) {
+			children, err = godirwalk.ReadDirents(d.osPathname, scratch)
+			return err
+		}, nil)
+		if err == errStall {
+			// Stalled read keeps using its scratch buffer
+			scratch = make([]byte, godirwalk.MinimumScratchBufferSize)
+			err = &os.PathError{Op: "readdir", Path: d.osPathname, Err: err}
+		}
+		if err != nil {
+			handleWalkError(opts, d.osPathname, err)
+			d.release(opts)
+			continue
+		}
+
+		for _, de := range children {
+			if opts.stopped {
+				break
+			}
+			if opts.nameCallback != nil {
+				opts.nameCallback(len(de.Name()))
+			}
+			childPathname := filepath.Join(d.osPathname, de.Name())
+			isDir := de.IsDir()
+			if opts.followSymlinks && de.IsSymlink() {
+				var err error
+				if isDir, err = isDirPath(childPathname); err != nil {
+					handleWalkError(opts, childPathname, err)
+				}
+			}
+			if isDir {
+				check(childPathname, d)
+			}
+		}
+		d.release(opts)
+	}
+
+	return nil
+}
+
+// countEntries reads all directory entries to count them.
+func (bestFirstEngine) countEntries(osPathname string) (dirCount, error) {
+	return godirwalkEngine{}.countEntries(osPathname)
+}
@@ -0,0 +1,435 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+//go:build linux || freebsd
+// +build linux freebsd
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// defaultWalkEngine is the walk engine used unless requested otherwise.
+const defaultWalkEngine = "fd"
+
+// direntBufferSize is getdents/getdirentries buffer size.
+const direntBufferSize = 64 * 1024
+
+// Offsets of syscall.Dirent fields common to Linux and FreeBSD.
+var (
+	direntReclenOffset = int(unsafe.Offsetof(syscall.Dirent{}.Reclen))
+	direntTypeOffset   = int(unsafe.Offsetof(syscall.Dirent{}.Type))
+	direntNameOffset   = int(unsafe.Offsetof(syscall.Dirent{}.Name))
+)
+
+// fdEngine is a walk engine which opens directories with openat() relative to
+// the parent directory descriptor, reads raw dirents and stats subdirectories
+// with fstatat() relative to the same descriptor.
+type fdEngine struct{}
+
+func init() {
+	walkEngines["fd"] = fdEngine{}
+}
+
+// fdLevel holds subdirectory names of a directory being walked. Levels are
+// reused for every directory at the same depth to keep allocations flat. Names
+// are stored NUL-terminated, ends holds the position of each terminator. Infos
+// hold stat results of subdirectories which had to be stat'ed to be found.
+type fdLevel struct {
+	names []byte
+	ends  []int
+	infos []*dirInfo
+}
+
+// collect will read subdirectory names of an open directory osPathname using buf, passing length of every name to
+// name callback. Symlinks to directories are collected as well if following symlinks.
+func (l *fdLevel) collect(fd int, osPathname string, buf *[]byte, opts *walkOptions) error {
+	l.names, l.ends, l.infos = l.names[:0], l.ends[:0], l.infos[:0]
+
+	return readDirents(fd, osPathname, buf, func(name []byte, typ uint8) {
+		if opts.nameCallback != nil {
+			opts.nameCallback(len(name))
+		}
+		var di *dirInfo
+		switch {
+		case typ == syscall.DT_DIR:
+		case typ == syscall.DT_UNKNOWN || (typ == syscall.DT_LNK && opts.followSymlinks):
+			var ok bool
+			if di, ok = statDirAt(fd, name, joinPath(osPathname, string(name)), opts); !ok {
+				return
+			}
+		default:
+			return
+		}
+		l.names = append(l.names, name...)
+		l.ends = append(l.ends, len(l.names))
+		l.names = append(l.names, 0)
+		l.infos = append(l.infos, di)
+	})
+}
+
+// statDirAt returns dirInfo of an entry of unknown type, or false if it is not a directory. Failures other than stalls
+// are not reported, as they just mean it is not a directory, like dangling symlinks.
+func statDirAt(fd int, name []byte, osPathname string, opts *walkOptions) (*dirInfo, bool) {
+	// Name is copied before the call, as a stalled call may outlive its buffer
+	di, err := statAtGuarded(fd, string(name), osPathname, opts.followSymlinks)
+	if err == errStall {
+		handleWalkError(opts, osPathname, &os.PathError{Op: "stat", Path: osPathname, Err: err})
+	}
+	if err != nil || !di.dir {
+		return nil, false
+	}
+	return di, true
+}
+
+// len returns number of collected subdirectories.
+func (l *fdLevel) len() int {
+	return len(l.ends)
+}
+
+// start returns position of i-th name.
+func (l *fdLevel) start(i int) int {
+	if i == 0 {
+		return 0
+	}
+	return l.ends[i-1] + 1
+}
+
+// name returns i-th subdirectory name.
+func (l *fdLevel) name(i int) string {
+	return string(l.names[l.start(i):l.ends[i]])
+}
+
+// info returns stat result of i-th subdirectory, or nil if it was not stat'ed yet.
+func (l *fdLevel) info(i int) *dirInfo {
+	return l.infos[i]
+}
+
+// namePtr returns pointer to i-th NUL-terminated subdirectory name.
+func (l *fdLevel) namePtr(i int) *byte {
+	return &l.names[l.start(i)]
+}
+
+// fdWalker holds state of a single directory tree walk.
+type fdWalker struct {
+	opts   *walkOptions
+	buf    []byte
+	levels []*fdLevel
+}
+
+// level returns reusable fdLevel for given depth.
+func (w *fdWalker) level(depth int) *fdLevel {
+	if depth == len(w.levels) {
+		w.levels = append(w.levels, &fdLevel{})
+	}
+	return w.levels[depth]
+}
+
+func (fdEngine) walk(rootPath string, opts *walkOptions) error {
+	fd, err := openRoot(rootPath, opts)
+	if err != nil || fd < 0 {
+		return err
+	}
+
+	w := &fdWalker{opts: opts, buf: make([]byte, direntBufferSize)}
+	w.walkDir(fd, rootPath, 0)
+	handleWalkDone(opts, rootPath)
+
+	return nil
+}
+
+// openRoot checks and opens the root directory. It returns -1 if the root directory is to be skipped. Root is checked
+// before it is opened, so that it gets checked even when it is unreadable.
+func openRoot(rootPath string, opts *walkOptions) (int, error) {
+	di, err := statAtGuarded(unix.AT_FDCWD, rootPath, rootPath, opts.followSymlinks)
+	if err != nil {
+		return -1, &os.PathError{Op: "stat", Path: rootPath, Err: err}
+	}
+
+	if err := opts.callback(rootPath, di); err != nil {
+		handleWalkError(opts, rootPath, err)
+		return -1, nil
+	}
+
+	fd, err := openDirGuarded(unix.AT_FDCWD, rootPath, rootPath, opts.followSymlinks)
+	if err != nil {
+		handleWalkError(opts, rootPath, err)
+		return -1, nil
+	}
+
+	return fd, nil
+}
+
+// walkDir will collect subdirectories of an open directory and then stat, check and descend into each of them,
+// closing the directory when done. Should descriptors run out, one per level being open, the directory is closed
+// early and the rest of its subdirectories are reached by pathname instead, so that deeper trees can still be walked.
+func (w *fdWalker) walkDir(fd int, osPathname string, depth int) {
+	level := w.level(depth)
+	if err := level.collect(fd, osPathname, &w.buf, w.opts); err != nil {
+		handleWalkError(w.opts, osPathname, &os.PathError{Op: "getdents", Path: osPathname, Err: err})
+	}
+
+	for i := 0; i < level.len() && !w.opts.stopped; i++ {
+		name := level.name(i)
+		childPathname := joinPath(osPathname, name)
+		if !w.checkChild(fd, name, childPathname, level.info(i)) {
+			continue
+		}
+
+		childFd, err := openDirGuarded(fd, relativeName(fd, name, childPathname), childPathname,
+			w.opts.followSymlinks)
+		if isOutOfDescriptors(err) && fd != unix.AT_FDCWD {
+			unix.Close(fd)
+			fd = unix.AT_FDCWD
+			childFd, err = openDirGuarded(fd, childPathname, childPathname, w.opts.followSymlinks)
+		}
+		if err != nil {
+			handleWalkError(w.opts, childPathname, err)
+			continue
+		}
+		w.walkDir(childFd, childPathname, depth+1)
+		handleWalkDone(w.opts, childPathname)
+	}
+
+	if fd != unix.AT_FDCWD {
+		unix.Close(fd)
+	}
+}
+
+// checkChild will stat subdirectory name of an open directory unless di is already known, and check it. It returns
+// true if the subdirectory is to be descended into.
+func (w *fdWalker) checkChild(fd int, name, childPathname string, di *dirInfo) bool {
+	if di == nil {
+		var err error
+		if di, err = statAtGuarded(fd, relativeName(fd, name, childPathname), childPathname,
+			w.opts.followSymlinks); err != nil {
+			handleWalkError(w.opts, childPathname, &os.PathError{Op: "stat", Path: childPathname, Err: err})
+			return false
+		}
+	}
+
+	if err := w.opts.callback(childPathname, di); err != nil {
+		handleWalkError(w.opts, childPathname, err)
+		return false
+	}
+	return true
+}
+
+// relativeName returns name of a subdirectory relative to fd, that is its pathname once fd is closed.
+func relativeName(fd int, name, childPathname string) string {
+	if fd == unix.AT_FDCWD {
+		return childPathname
+	}
+	return name
+}
+
+// isOutOfDescriptors checks if error is due to running out of file descriptors.
+func isOutOfDescriptors(err error) bool {
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE)
+}
+
+func (fdEngine) countEntries(osPathname string) (dirCount, error) {
+	fd, err := openDirGuarded(unix.AT_FDCWD, osPathname, osPathname, false)
+	if err != nil {
+		return dirCount{}, err
+	}
+	defer unix.Close(fd)
+
+	var count dirCount
+	var stalled error
+	buf := make([]byte, direntBufferSize)
+	err = readDirents(fd, osPathname, &buf, func(name []byte, typ uint8) {
+		count.entries++
+		if typ == syscall.DT_UNKNOWN && stalled == nil {
+			childPathname := joinPath(osPathname, string(name))
+			di, err := statAtGuarded(fd, string(name), childPathname, false)
+			if err == errStall {
+				stalled = &os.PathError{Op: "stat", Path: childPathname, Err: err}
+			} else if err == nil && di.dir {
+				count.subdirs++
+			}
+		} else if typ == syscall.DT_DIR {
+			count.subdirs++
+		}
+	})
+	if err != nil {
+		return dirCount{}, &os.PathError{Op: "getdents", Path: osPathname, Err: err}
+	}
+	if stalled != nil {
+		return dirCount{}, stalled
+	}
+
+	return count, nil
+}
+
+// fstatatFlags returns fstatat() flags to follow symlinks only if requested.
+func fstatatFlags(follow bool) int {
+	if follow {
+		return 0
+	}
+	return unix.AT_SYMLINK_NOFOLLOW
+}
+
+// readDirents reads all entries of an open directory osPathname using buf and calls fn
+// for every entry except "." and "..". Name passed to fn is only valid during the call.
+// Buffer of a stalled read is left to it and replaced with a new one.
+func readDirents(fd int, osPathname string, buf *[]byte, fn func(name []byte, typ uint8)) error {
+	for {
+		metadataThrottle.wait(1)
+
+		// syscall.ReadDirent is used instead of unix.ReadDirent, as Isilon mode patches the former
+		var n int
+		b := *buf
+		err := guardCall("getdents", osPathname, func() (err error) {
+			n, err = syscall.ReadDirent(fd, b)
+			return err
+		}, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if errors.Is(err, errStall) {
+			*buf = make([]byte, len(b))
+		}
+		if err != nil {
+			return err
+		}
+		if n <= 0 {
+			return nil
+		}
+
+		for rec := b[:n]; len(rec) > direntNameOffset; {
+			reclen := int(*(*uint16)(unsafe.Pointer(&rec[direntReclenOffset])))
+			if reclen == 0 || reclen > len(rec) {
+				break
+			}
+
+			if direntIno(rec) != 0 {
+				name := direntName(rec[:reclen])
+				if !isDotOrDotDot(name) {
+					fn(name, rec[direntTypeOffset])
+				}
+			}
+			rec = rec[reclen:]
+		}
+	}
+}
+
+// direntIno returns inode number of a single dirent record, it is the first field on both Linux and FreeBSD.
+func direntIno(rec []byte) uint64 {
+	return *(*uint64)(unsafe.Pointer(&rec[0]))
+}
+
+// direntName returns name of a single dirent record.
+func direntName(rec []byte) []byte {
+	name := rec[direntNameOffset:]
+	if i := bytes.IndexByte(name, 0); i >= 0 {
+		return name[:i]
+	}
+	return name
+}
+
+// isDotOrDotDot checks if name is "." or "..".
+func isDotOrDotDot(name []byte) bool {
+	return len(name) > 0 && len(name) <= 2 && name[0] == '.' && (len(name) == 1 || name[1] == '.')
+}
+
+// openDirFlags returns flags to open a directory, following symlinks only if requested.
+func openDirFlags(follow bool) int {
+	if follow {
+		return unix.O_RDONLY | unix.O_DIRECTORY | unix.O_CLOEXEC
+	}
+	return unix.O_RDONLY | unix.O_DIRECTORY | unix.O_NOFOLLOW | unix.O_CLOEXEC
+}
+
+// openDirAt opens a directory relative to dirfd, following symlinks only if requested.
+func openDirAt(dirfd int, name string, follow bool) (int, error) {
+	for {
+		fd, err := unix.Openat(dirfd, name, openDirFlags(follow), 0)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return -1, &os.PathError{Op: "openat", Path: name, Err: err}
+		}
+		return fd, nil
+	}
+}
+
+// statAtGuarded returns dirInfo of name relative to dirfd as statAt does, giving up on stalled calls on osPathname.
+func statAtGuarded(dirfd int, name, osPathname string, follow bool) (*dirInfo, error) {
+	metadataThrottle.wait(1)
+
+	var di *dirInfo
+	err := guardCall("stat", osPathname, func() (err error) {
+		di, err = statAt(dirfd, name, follow)
+		return err
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return di, nil
+}
+
+// openDirGuarded opens a directory relative to dirfd as openDirAt does, giving up on stalled calls on osPathname.
+// Directory opened by a stalled call is closed once the call finishes.
+func openDirGuarded(dirfd int, name, osPathname string, follow bool) (int, error) {
+	metadataThrottle.wait(1)
+
+	fd := -1
+	err := guardCall("openat", osPathname, func() (err error) {
+		fd, err = openDirAt(dirfd, name, follow)
+		return err
+	}, func() { unix.Close(fd) })
+	if err == errStall {
+		return -1, &os.PathError{Op: "openat", Path: osPathname, Err: err}
+	}
+	if err != nil {
+		return -1, err
+	}
+	return fd, nil
+}
+
+// joinPath joins parent and child pathname without cleaning them.
+func joinPath(parent, name string) string {
+	if len(parent) > 0 && parent[len(parent)-1] == filepath.Separator {
+		return parent + name
+	}
+	return parent + string(filepath.Separator) + name
+}
+
+// statToDirInfo converts fstatat() results to dirInfo.
+func statToDirInfo(st *unix.Stat_t) *dirInfo {
+	return &dirInfo{
+		size:  st.Size,
+		dev:   uint64(st.Dev),
+		ino:   uint64(st.Ino),
+		nlink: uint64(st.Nlink),
+		dir:   st.Mode&unix.S_IFMT == unix.S_IFDIR,
+	}
+}
@@ -0,0 +1,113 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+//go:build linux || freebsd
+// +build linux freebsd
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestWalkOutOfDescriptors(t *testing.T) {
+	// Tree deeper than descriptor limit, with siblings to fill uring batches
+	const depth = 100
+	root := t.TempDir()
+	for _, d := range []string{strings.Repeat("d/", depth), "e", "f", "g"} {
+		if err := os.MkdirAll(filepath.Join(root, d), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var saved unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_NOFILE, &saved); err != nil {
+		t.Fatal(err)
+	}
+	limit := saved
+	limit.Cur = 64
+	if err := unix.Setrlimit(unix.RLIMIT_NOFILE, &limit); err != nil {
+		t.Skip(err)
+	}
+	defer unix.Setrlimit(unix.RLIMIT_NOFILE, &saved)
+
+	for name, e := range walkEngines {
+		if name != "fd" && name != "uring" {
+			continue
+		}
+		e := e
+		t.Run(name, func(t *testing.T) {
+			checked := 0
+			err := e.walk(root, &walkOptions{
+				callback: func(osPathname string, di *dirInfo) error {
+					checked++
+					return nil
+				},
+				errorCallback: func(osPathname string, err error) {
+					t.Errorf("unexpected error on %q: %v", osPathname, err)
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := depth + 4; checked != want {
+				t.Errorf("checked %v directories; want %v", checked, want)
+			}
+		})
+	}
+}
+
+func TestWalkThrottledNotStalled(t *testing.T) {
+	root := makeTestTree(t)
+
+	// Each operation waits up to 20ms for the throttle, longer than the stall timeout
+	savedThrottle, savedTimeout := metadataThrottle, *stallTimeout
+	defer func() { metadataThrottle, *stallTimeout = savedThrottle, savedTimeout }()
+	metadataThrottle = newOpsThrottle(50, nil)
+	*stallTimeout = 10 * time.Millisecond
+
+	if _, err := statPath(root, false); err != nil {
+		t.Errorf("statPath() returned %v", err)
+	}
+
+	checked := 0
+	err := walkEngines["fd"].walk(root, &walkOptions{
+		callback: func(osPathname string, di *dirInfo) error {
+			checked++
+			return nil
+		},
+		errorCallback: func(osPathname string, err error) {
+			t.Errorf("unexpected error on %q: %v", osPathname, err)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 8; checked != want {
+		t.Errorf("checked %v directories; want %v", checked, want)
+	}
+}
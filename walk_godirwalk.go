@@ -0,0 +1,112 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"os"
+
+	"github.com/karrick/godirwalk"
+)
+
+// godirwalkEngine is a portable walk engine based on godirwalk, calling os.Stat on each directory.
+type godirwalkEngine struct{}
+
+func init() {
+	walkEngines["godirwalk"] = godirwalkEngine{}
+}
+
+// walk uses fast concurrent directory walker: follows symlinks only if requested and won't sort entries.
+func (godirwalkEngine) walk(rootPath string, opts *walkOptions) error {
+	err := godirwalk.Walk(rootPath, &godirwalk.Options{
+		Unsorted:            true,
+		FollowSymbolicLinks: opts.followSymlinks,
+		// Default callback will process only directory entries
+		Callback: func(osPathname string, de *godirwalk.Dirent) error {
+			if opts.nameCallback != nil {
+				opts.nameCallback(len(de.Name()))
+			}
+			isDir := de.IsDir()
+			if opts.followSymlinks && de.IsSymlink() {
+				// Symlinks to anything but directories are skipped before godirwalk stats them again
+				var err error
+				if isDir, err = isDirPath(osPathname); err != nil {
+					return err
+				}
+				if !isDir {
+					return godirwalk.SkipThis
+				}
+			}
+			if !isDir {
+				return nil
+			}
+
+			di, err := statPath(osPathname, opts.followSymlinks)
+			if err != nil {
+				return err
+			}
+
+			return opts.callback(osPathname, di)
+		},
+		PostChildrenCallback: func(osPathname string, de *godirwalk.Dirent) error {
+			handleWalkDone(opts, osPathname)
+			return nil
+		},
+		// Default error callback will just skip over when encountering errors
+		ErrorCallback: func(osPathname string, err error) godirwalk.ErrorAction {
+			handleWalkError(opts, osPathname, err)
+			if opts.stopped {
+				return godirwalk.Halt
+			}
+			return godirwalk.SkipNode
+		},
+	})
+	if err == errStopWalk {
+		return nil
+	}
+
+	return err
+}
+
+// countEntries reads all directory entries to count them.
+func (godirwalkEngine) countEntries(osPathname string) (dirCount, error) {
+	metadataThrottle.wait(1)
+	var deChildren godirwalk.Dirents
+	err := guardCall("readdir", osPathname, func() (err error) {
+		deChildren, err = godirwalk.ReadDirents(osPathname, nil)
+		return err
+	}, nil)
+	if err == errStall {
+		return dirCount{}, &os.PathError{Op: "readdir", Path: osPathname, Err: err}
+	}
+	if err != nil {
+		return dirCount{}, err
+	}
+
+	count := dirCount{entries: int64(len(deChildren))}
+	for _, de := range deChildren {
+		if de.IsDir() {
+			count.subdirs++
+		}
+	}
+
+	return count, nil
+}
@@ -0,0 +1,268 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// makeTestTree creates a small directory tree and returns its root.
+func makeTestTree(t *testing.T) string {
+	t.Helper()
+
+	root := t.TempDir()
+	for _, d := range []string{"a/b/c", "a/d", "e", "skip/f"} {
+		if err := os.MkdirAll(filepath.Join(root, d), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []string{"a/b/c/1", "a/b/c/2", "a/b/c/3", "e/1"} {
+		if err := os.WriteFile(filepath.Join(root, f), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(root, "a"), filepath.Join(root, "e", "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	return root
+}
+
+func TestWalkEngines(t *testing.T) {
+	root := makeTestTree(t)
+	want := []string{".", "a", "a/b", "a/b/c", "a/d", "e", "skip"}
+
+	for name, e := range walkEngines {
+		e := e
+		t.Run(name, func(t *testing.T) {
+			var got []string
+			err := e.walk(root, &walkOptions{
+				callback: func(osPathname string, di *dirInfo) error {
+					rel, _ := filepath.Rel(root, osPathname)
+					got = append(got, filepath.ToSlash(rel))
+					if rel == "skip" {
+						return filepath.SkipDir
+					}
+					return nil
+				},
+				errorCallback: func(osPathname string, err error) {
+					t.Errorf("unexpected error on %q: %v", osPathname, err)
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("walked %q; want %q", got, want)
+			}
+
+			count, err := e.countEntries(filepath.Join(root, "a", "b", "c"))
+			if err != nil || count.entries != 3 || count.subdirs != 0 {
+				t.Errorf("countEntries() = %+v, %v; want 3 entries", count, err)
+			}
+
+			count, err = e.countEntries(filepath.Join(root, "e"))
+			if err != nil || count.entries != 2 || count.subdirs != 0 {
+				t.Errorf("countEntries() = %+v, %v; want 2 entries", count, err)
+			}
+
+			count, err = e.countEntries(filepath.Join(root, "a"))
+			if err != nil || count.entries != 2 || count.subdirs != 2 {
+				t.Errorf("countEntries() = %+v, %v; want 2 entries and 2 subdirectories", count, err)
+			}
+		})
+	}
+}
+
+func TestBestFirstOrder(t *testing.T) {
+	root := t.TempDir()
+	for _, d := range []string{"a/x", "b/y"} {
+		if err := os.MkdirAll(filepath.Join(root, d), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Make b inode larger than a inode
+	for i := 0; i < 500; i++ {
+		if err := os.WriteFile(filepath.Join(root, "b", fmt.Sprintf("file-with-a-rather-long-name-%v", i)), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	err := bestFirstEngine{}.walk(root, &walkOptions{
+		callback: func(osPathname string, di *dirInfo) error {
+			rel, _ := filepath.Rel(root, osPathname)
+			got = append(got, filepath.ToSlash(rel))
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 5 || got[3] != "b/y" || got[4] != "a/x" {
+		t.Errorf("walk() order = %v; want b/y before a/x", got)
+	}
+}
+
+func TestWalkStop(t *testing.T) {
+	root := makeTestTree(t)
+
+	for name, e := range walkEngines {
+		e := e
+		t.Run(name, func(t *testing.T) {
+			var got int
+			err := e.walk(root, &walkOptions{
+				callback: func(osPathname string, di *dirInfo) error {
+					got++
+					if got == 3 {
+						return errStopWalk
+					}
+					return nil
+				},
+				errorCallback: func(osPathname string, err error) {
+					t.Errorf("unexpected error on %q: %v", osPathname, err)
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != 3 {
+				t.Errorf("walk() called back for %v directories; want 3", got)
+			}
+		})
+	}
+}
+
+func TestPathDepth(t *testing.T) {
+	sep := string(os.PathSeparator)
+	cases := []struct {
+		root, path string
+		want       int
+	}{
+		{"data", "data", 0},
+		{"data", "data" + sep + "a", 1},
+		{"data", "data" + sep + "a" + sep + "b", 2},
+		{sep, sep + "a" + sep + "b", 2},
+	}
+
+	for _, tc := range cases {
+		if got := pathDepth(tc.root, tc.path); got != tc.want {
+			t.Errorf("pathDepth(%q, %q) = %v; want %v", tc.root, tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestWalkDone(t *testing.T) {
+	root := makeTestTree(t)
+	want := []string{".", "a", "a/b", "a/b/c", "a/d", "e"}
+
+	for name, e := range walkEngines {
+		e := e
+		t.Run(name, func(t *testing.T) {
+			checked, isDone := map[string]bool{}, map[string]bool{}
+			var done []string
+			err := e.walk(root, &walkOptions{
+				callback: func(osPathname string, di *dirInfo) error {
+					checked[osPathname] = true
+					if filepath.Base(osPathname) == "skip" {
+						return filepath.SkipDir
+					}
+					return nil
+				},
+				doneCallback: func(osPathname string) {
+					// Every walked subdirectory has to be done before its parent
+					for d := range checked {
+						if filepath.Dir(d) == osPathname && filepath.Base(d) != "skip" && !isDone[d] {
+							t.Errorf("%q done before its subdirectory %q", osPathname, d)
+						}
+					}
+					isDone[osPathname] = true
+					done = append(done, osPathname)
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, d := range done {
+				rel, _ := filepath.Rel(root, d)
+				got = append(got, filepath.ToSlash(rel))
+			}
+			if len(got) == 0 || got[len(got)-1] != "." {
+				t.Errorf("root is not done last: %v", got)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("done %v; want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestWalkFollowSymlinks(t *testing.T) {
+	root := makeTestTree(t)
+	// Loop back to root, symlink to a file and a dangling one are not walked
+	for link, target := range map[string]string{"a/d/loop": root, "a/file": "../e/1", "a/dangling": "missing"} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, e := range walkEngines {
+		e := e
+		t.Run(name, func(t *testing.T) {
+			visited := visitedDirs{}
+			var got []string
+			err := e.walk(root, &walkOptions{
+				callback: func(osPathname string, di *dirInfo) error {
+					if !visited.visit(di) {
+						return filepath.SkipDir
+					}
+					rel, _ := filepath.Rel(root, osPathname)
+					got = append(got, filepath.ToSlash(rel))
+					return nil
+				},
+				errorCallback: func(osPathname string, err error) {
+					t.Errorf("unexpected error on %q: %v", osPathname, err)
+				},
+				followSymlinks: true,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Directory a is reached either directly or through e/link, but only once
+			if len(got) != 8 {
+				t.Errorf("walked %q; want 8 directories", got)
+			}
+		})
+	}
+}
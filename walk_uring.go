@@ -0,0 +1,310 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"log"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/dkorunic/findlargedir/uring"
+	"golang.org/x/sys/unix"
+)
+
+// uringEngine is a Linux walk engine which works as fd engine, but submits statx() and openat() calls of sibling
+// directories in batches through io_uring. Directories are still read synchronously, as io_uring has no getdents.
+type uringEngine struct{}
+
+// uringFallbackOnce makes sure fallback to fd engine is logged only once.
+var uringFallbackOnce sync.Once
+
+// abandonedLevels keeps buffers of entries left in flight by a failed ring alive, as the kernel may still use them.
+var abandonedLevels struct {
+	sync.Mutex
+	levels []interface{}
+}
+
+func init() {
+	walkEngines["uring"] = uringEngine{}
+}
+
+// uringLevel holds batch results for a directory being walked, reused for every directory at the same depth.
+type uringLevel struct {
+	stx  []unix.Statx_t
+	res  []int32
+	open []bool
+}
+
+// uringWalker holds state of a single directory tree walk.
+type uringWalker struct {
+	fdWalker
+	ring    *uring.Ring
+	batch   int
+	ulevels []*uringLevel
+	// ringFailed is set once waiting for a batch fails, the ring is not used after that
+	ringFailed bool
+}
+
+// newRing creates io_uring instance and checks if it supports all needed operations (Linux >= 5.6).
+func newRing() (*uring.Ring, error) {
+	ring, err := uring.New(uint32(*queueDepth))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ring.Probe(uring.OpStatx, uring.OpOpenat); err != nil {
+		ring.Close()
+		return nil, err
+	}
+
+	return ring, nil
+}
+
+func (uringEngine) walk(rootPath string, opts *walkOptions) error {
+	ring, err := newRing()
+	if err != nil {
+		uringFallbackOnce.Do(func() {
+			log.Printf("Unable to use io_uring (%v), falling back to fd walk engine.", err)
+		})
+		return fdEngine{}.walk(rootPath, opts)
+	}
+	defer ring.Close()
+
+	fd, err := openRoot(rootPath, opts)
+	if err != nil || fd < 0 {
+		return err
+	}
+
+	batch := *queueDepth
+	if batch > ring.Entries() || batch < 1 {
+		batch = ring.Entries()
+	}
+
+	w := &uringWalker{
+		fdWalker: fdWalker{opts: opts, buf: make([]byte, direntBufferSize)},
+		ring:     ring,
+		batch:    batch,
+	}
+	w.walkDir(fd, rootPath, 0)
+	handleWalkDone(opts, rootPath)
+
+	return nil
+}
+
+// ulevel returns reusable uringLevel for given depth.
+func (w *uringWalker) ulevel(depth int) *uringLevel {
+	if depth == len(w.ulevels) {
+		w.ulevels = append(w.ulevels, &uringLevel{
+			stx:  make([]unix.Statx_t, w.batch),
+			res:  make([]int32, w.batch),
+			open: make([]bool, w.batch),
+		})
+	}
+	return w.ulevels[depth]
+}
+
+// failRing will stop using the ring after waiting for a batch of directory at given depth has failed. Wait normally
+// reaps everything it has submitted even then, but should entries remain in flight, buffers of the depth are never
+// reused. Descriptors such entries may still open are lost.
+func (w *uringWalker) failRing(osPathname string, depth int, err error) {
+	handleWalkError(w.opts, osPathname, err)
+	w.ringFailed = true
+	if w.ring.Pending() == 0 {
+		return
+	}
+
+	abandonedLevels.Lock()
+	abandonedLevels.levels = append(abandonedLevels.levels, w.levels[depth], w.ulevels[depth])
+	abandonedLevels.Unlock()
+	w.levels[depth] = &fdLevel{}
+	w.ulevels[depth] = &uringLevel{}
+}
+
+// walkDir will collect subdirectories of an open directory and then stat, check and descend into them batch by batch,
+// closing the directory when done. Once the ring has failed, the rest of the tree is walked as by fd engine. Should
+// descriptors run out even with no batch open ahead, the rest of the directory is reached by pathname as well.
+func (w *uringWalker) walkDir(fd int, osPathname string, depth int) {
+	if w.ringFailed {
+		w.fdWalker.walkDir(fd, osPathname, depth)
+		return
+	}
+	defer func() {
+		if fd != unix.AT_FDCWD {
+			unix.Close(fd)
+		}
+	}()
+
+	level := w.level(depth)
+	if err := level.collect(fd, osPathname, &w.buf, w.opts); err != nil {
+		handleWalkError(w.opts, osPathname, &os.PathError{Op: "getdents", Path: osPathname, Err: err})
+	}
+
+	ul := w.ulevel(depth)
+	for start := 0; start < level.len() && !w.opts.stopped; start += w.batch {
+		if w.ringFailed || fd == unix.AT_FDCWD {
+			w.walkRest(fd, osPathname, level, start, depth)
+			return
+		}
+
+		end := start + w.batch
+		if end > level.len() {
+			end = level.len()
+		}
+
+		// Batch statx() of all subdirectories, except those already stat'ed to be found
+		stated := 0
+		for i := start; i < end; i++ {
+			if level.info(i) == nil {
+				w.ring.PrepareStatx(fd, level.namePtr(i), statxFlags(w.opts.followSymlinks), statxMask, &ul.stx[i-start], uint64(i-start))
+				stated++
+			}
+		}
+		metadataThrottle.wait(stated)
+		if err := w.ring.Wait(func(userData uint64, res int32) { ul.res[userData] = res }); err != nil {
+			// Nothing was checked yet, so walk this batch and the rest without the ring
+			w.failRing(osPathname, depth, err)
+			w.walkRest(fd, osPathname, level, start, depth)
+			return
+		}
+
+		// Check each subdirectory and batch openat() of those to descend into
+		opened := 0
+		for i := start; i < end; i++ {
+			ul.open[i-start] = false
+			if w.opts.stopped {
+				continue
+			}
+			childPathname := joinPath(osPathname, level.name(i))
+
+			di := level.info(i)
+			if di == nil {
+				if res := ul.res[i-start]; res < 0 {
+					handleWalkError(w.opts, childPathname, &os.PathError{Op: "statx", Path: childPathname,
+						Err: syscall.Errno(-res)})
+					continue
+				}
+				di = statxToDirInfo(&ul.stx[i-start])
+			}
+
+			if err := w.opts.callback(childPathname, di); err != nil {
+				handleWalkError(w.opts, childPathname, err)
+				continue
+			}
+
+			ul.open[i-start] = true
+			ul.res[i-start] = -int32(syscall.ECANCELED)
+			opened++
+			w.ring.PrepareOpenat(fd, level.namePtr(i), openDirFlags(w.opts.followSymlinks), 0, uint64(i-start))
+		}
+		metadataThrottle.wait(opened)
+		if err := w.ring.Wait(func(userData uint64, res int32) { ul.res[userData] = res }); err != nil {
+			// Directories opened by completed entries are descended into and closed as usual, the rest is opened again
+			w.failRing(osPathname, depth, err)
+		}
+
+		// Descend into each opened subdirectory
+		for i := start; i < end; i++ {
+			if !ul.open[i-start] {
+				continue
+			}
+
+			name := level.name(i)
+			childPathname := joinPath(osPathname, name)
+
+			childFd := int(ul.res[i-start])
+			if errno := syscall.Errno(-childFd); childFd < 0 && (errno == syscall.EMFILE || errno == syscall.ENFILE ||
+				errno == syscall.ECANCELED) {
+				// Out of descriptors while the whole batch was open, or not opened due to ring failure: retry now that
+				// previous ones are closed, and if still out of them once the rest of the batch is closed too
+				var err error
+				childFd, err = openDirGuarded(fd, relativeName(fd, name, childPathname), childPathname,
+					w.opts.followSymlinks)
+				if isOutOfDescriptors(err) && ul.releaseAfter(i-start, end-start) {
+					childFd, err = openDirGuarded(fd, relativeName(fd, name, childPathname), childPathname,
+						w.opts.followSymlinks)
+				}
+				if isOutOfDescriptors(err) && fd != unix.AT_FDCWD {
+					unix.Close(fd)
+					fd = unix.AT_FDCWD
+					childFd, err = openDirGuarded(fd, childPathname, childPathname, w.opts.followSymlinks)
+				}
+				if err != nil {
+					handleWalkError(w.opts, childPathname, err)
+					continue
+				}
+			} else if childFd < 0 {
+				handleWalkError(w.opts, childPathname, &os.PathError{Op: "openat", Path: childPathname, Err: errno})
+				continue
+			}
+
+			if w.opts.stopped {
+				unix.Close(childFd)
+			} else {
+				w.walkDir(childFd, childPathname, depth+1)
+			}
+			handleWalkDone(w.opts, childPathname)
+		}
+	}
+}
+
+// releaseAfter will close subdirectories of the batch after i-th opened ahead of their turn, so that they are opened
+// again when it comes. It returns true if any was closed.
+func (ul *uringLevel) releaseAfter(i, n int) bool {
+	released := false
+	for j := i + 1; j < n; j++ {
+		if ul.open[j] && ul.res[j] >= 0 {
+			unix.Close(int(ul.res[j]))
+			ul.res[j] = -int32(syscall.ECANCELED)
+			released = true
+		}
+	}
+	return released
+}
+
+// walkRest will stat, check and descend into subdirectories of an open directory from i-th on without the ring.
+func (w *uringWalker) walkRest(fd int, osPathname string, level *fdLevel, i, depth int) {
+	for ; i < level.len() && !w.opts.stopped; i++ {
+		name := level.name(i)
+		childPathname := joinPath(osPathname, name)
+		if !w.checkChild(fd, name, childPathname, level.info(i)) {
+			continue
+		}
+
+		childFd, err := openDirGuarded(fd, relativeName(fd, name, childPathname), childPathname,
+			w.opts.followSymlinks)
+		if err != nil {
+			handleWalkError(w.opts, childPathname, err)
+			continue
+		}
+		w.fdWalker.walkDir(childFd, childPathname, depth+1)
+		handleWalkDone(w.opts, childPathname)
+	}
+}
+
+// countEntries reads directories synchronously, as io_uring has no getdents.
+func (uringEngine) countEntries(osPathname string) (dirCount, error) {
+	return fdEngine{}.countEntries(osPathname)
+}
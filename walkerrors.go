@@ -0,0 +1,125 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"syscall"
+)
+
+const defaultErrorPaths = 10
+
+// walkErrorClass is a count of errors of the same class and first pathnames they were encountered on.
+type walkErrorClass struct {
+	Class string   `json:"class"`
+	Count int64    `json:"count"`
+	Paths []string `json:"paths"`
+}
+
+// walkErrors collects walk errors by class, safe for concurrent use.
+type walkErrors struct {
+	mu       sync.Mutex
+	maxPaths int
+	total    int64
+	classes  map[string]*walkErrorClass
+}
+
+// newWalkErrors returns walkErrors keeping up to maxPaths pathnames per error class.
+func newWalkErrors(maxPaths int) *walkErrors {
+	return &walkErrors{maxPaths: maxPaths, classes: map[string]*walkErrorClass{}}
+}
+
+// add will record an error encountered on osPathname.
+func (e *walkErrors) add(osPathname string, err error) {
+	class := errorClass(err)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c, ok := e.classes[class]
+	if !ok {
+		c = &walkErrorClass{Class: class}
+		e.classes[class] = c
+	}
+	c.Count++
+	if len(c.Paths) < e.maxPaths {
+		c.Paths = append(c.Paths, osPathname)
+	}
+	e.total++
+}
+
+// count returns total number of errors recorded.
+func (e *walkErrors) count() int64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.total
+}
+
+// summary returns error classes, most frequent first.
+func (e *walkErrors) summary() []walkErrorClass {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	s := make([]walkErrorClass, 0, len(e.classes))
+	for _, c := range e.classes {
+		s = append(s, walkErrorClass{Class: c.Class, Count: c.Count, Paths: append([]string(nil), c.Paths...)})
+	}
+	sort.Slice(s, func(i, j int) bool {
+		if s[i].Count != s[j].Count {
+			return s[i].Count > s[j].Count
+		}
+		return s[i].Class < s[j].Class
+	})
+
+	return s
+}
+
+// report will log error counts by class and first pathnames of each class.
+func (e *walkErrors) report(rootPath string) {
+	if e.count() == 0 {
+		return
+	}
+
+	log.Printf("Encountered %v errors while walking %q, some directories were not checked.", e.count(), rootPath)
+	for _, c := range e.summary() {
+		log.Printf("%v errors: %v, first on %q.", c.Class, c.Count, c.Paths)
+	}
+}
+
+// errorClass returns error class name: errno name for system call errors, "STALLED" for stalled calls and "other"
+// for the rest.
+func errorClass(err error) string {
+	if errors.Is(err, errStall) {
+		return "STALLED"
+	}
+
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return errnoName(errno)
+	}
+
+	return "other"
+}
@@ -0,0 +1,52 @@
+// @license
+// Copyright (C) 2018  Dinko Korunic
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestWalkErrors(t *testing.T) {
+	e := newWalkErrors(2)
+	e.add("/a", &os.PathError{Op: "open", Path: "/a", Err: syscall.ENOENT})
+	e.add("/b", errors.New("no inode ratio"))
+	e.add("/c", syscall.EACCES)
+	e.add("/d", &os.PathError{Op: "stat", Path: "/d", Err: syscall.ENOENT})
+	e.add("/e", &os.PathError{Op: "open", Path: "/e", Err: syscall.ENOENT})
+
+	if got := e.count(); got != 5 {
+		t.Errorf("count() = %v, want 5", got)
+	}
+
+	want := []walkErrorClass{
+		{Class: errnoName(syscall.ENOENT), Count: 3, Paths: []string{"/a", "/d"}},
+		{Class: errnoName(syscall.EACCES), Count: 1, Paths: []string{"/c"}},
+		{Class: "other", Count: 1, Paths: []string{"/b"}},
+	}
+	if got := e.summary(); !reflect.DeepEqual(got, want) {
+		t.Errorf("summary() = %+v, want %+v", got, want)
+	}
+}
@@ -19,16 +19,110 @@
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
 // SOFTWARE.
 
+//go:build !windows
 // +build !windows
 
 package main
 
 import (
-	"os"
-	"syscall"
+	"fmt"
+	"path/filepath"
 )
 
-// isSameFilesystem compares if two entries have the same root device number st_dev.
-func isSameFilesystem(rootStat, osStat os.FileInfo) bool {
-	return rootStat.Sys().(*syscall.Stat_t).Dev == osStat.Sys().(*syscall.Stat_t).Dev
+// isSameFilesystem compares if two entries have the same mount ID stx_mnt_id when available (Linux >= 5.8), or
+// otherwise the same root device number st_dev.
+func isSameFilesystem(rootStat, osStat *dirInfo) bool {
+	if rootStat.hasMntID && osStat.hasMntID {
+		return rootStat.mntID == osStat.mntID
+	}
+	return rootStat.dev == osStat.dev
+}
+
+// keySource is where boundary keys come from.
+type keySource int
+
+const (
+	keyMountTable keySource = iota
+	keyStatfs
+	keyDevice
+)
+
+// filesystemBoundary detects directories across filesystem boundaries of a root directory.
+type filesystemBoundary struct {
+	mode     string
+	rootStat *dirInfo
+	rootKey  string
+	// source is where the root key came from, keys of all other directories come from there as well
+	source keySource
+	// keys caches boundary keys by mount ID, or by device when mount ID is unknown
+	keys map[uint64]string
+}
+
+// newFilesystemBoundary returns boundary of a root directory in given mode.
+func newFilesystemBoundary(mode, rootPath string, rootStat *dirInfo) *filesystemBoundary {
+	b := &filesystemBoundary{mode: mode, rootStat: rootStat, keys: map[uint64]string{}}
+	for b.source = keyMountTable; ; b.source++ {
+		if k, ok := boundaryKey(b.source, mode, rootPath, rootStat); ok {
+			b.rootKey = k
+			return b
+		}
+	}
+}
+
+// crosses checks if a directory is across the boundary. Directories on the same mount or device as the root directory
+// never are, so boundary keys are looked up only for the few others.
+func (b *filesystemBoundary) crosses(osPathname string, di *dirInfo) bool {
+	if isSameFilesystem(b.rootStat, di) {
+		return false
+	}
+	if b.mode == boundaryMount && b.rootStat.hasMntID && di.hasMntID {
+		return true
+	}
+	return b.key(osPathname, di) != b.rootKey
+}
+
+// describe returns why a directory across the boundary is skipped.
+func (b *filesystemBoundary) describe() string {
+	switch b.mode {
+	case boundaryFilesystem:
+		return "is on another filesystem"
+	case boundaryPool:
+		return "is in another pool"
+	}
+	return "is a mount point"
+}
+
+// key returns cached boundary key of a directory from the source of the root key. Directories that source has no key
+// for get an empty one, as across the boundary: they are on another mount or device than the root anyway.
+func (b *filesystemBoundary) key(osPathname string, di *dirInfo) string {
+	id := di.dev
+	if di.hasMntID {
+		id = di.mntID
+	}
+	if k, ok := b.keys[id]; ok {
+		return k
+	}
+
+	k, _ := boundaryKey(b.source, b.mode, osPathname, di)
+	b.keys[id] = k
+	return k
+}
+
+// boundaryKey returns key identifying the mount, filesystem or pool of a directory from given source: the mount table,
+// statfs() filesystem ID where available (telling apart only filesystems and pools) or device, which always has one.
+func boundaryKey(source keySource, mode, osPathname string, di *dirInfo) (string, bool) {
+	switch source {
+	case keyMountTable:
+		absPathname, err := filepath.Abs(osPathname)
+		if err != nil {
+			return "", false
+		}
+		return mountBoundaryKey(mode, absPathname, di)
+	case keyStatfs:
+		if mode == boundaryMount {
+			return "", false
+		}
+		return statfsID(osPathname)
+	}
+	return fmt.Sprintf("dev:%v", di.dev), true
 }
@@ -19,15 +19,30 @@
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
 // SOFTWARE.
 
+//go:build windows
 // +build windows
 
 package main
 
-import (
-	"os"
-)
-
 // isSameFilesystem always return true on Windows.
-func isSameFilesystem(rootStat, osStat os.FileInfo) bool {
+func isSameFilesystem(rootStat, osStat *dirInfo) bool {
 	return true
 }
+
+// filesystemBoundary is a dummy boundary on Windows.
+type filesystemBoundary struct{}
+
+// newFilesystemBoundary returns a dummy boundary on Windows.
+func newFilesystemBoundary(mode, rootPath string, rootStat *dirInfo) *filesystemBoundary {
+	return &filesystemBoundary{}
+}
+
+// crosses always returns false on Windows.
+func (b *filesystemBoundary) crosses(osPathname string, di *dirInfo) bool {
+	return false
+}
+
+// describe returns why a directory across the boundary is skipped.
+func (b *filesystemBoundary) describe() string {
+	return "is a mount point"
+}